@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Aggregation defines the type of the aggregation specifier
+type Aggregation string
+
+const (
+	// AggregationMean reduces tile values to their mean.
+	AggregationMean = "mean"
+
+	// AggregationMedian reduces tile values to their median.
+	AggregationMedian = "median"
+
+	// AggregationSum reduces tile values to their sum.
+	AggregationSum = "sum"
+)
+
+// aggregate reduces a set of per-pixel values to a single value using the requested aggregation.
+func aggregate(values []float64, aggregation Aggregation) (float64, error) {
+	switch aggregation {
+	case AggregationMean:
+		return mean(values), nil
+	case AggregationMedian:
+		return median(values), nil
+	case AggregationSum:
+		return sum(values), nil
+	default:
+		return 0, errors.Errorf("unrecognized aggregation %s", aggregation)
+	}
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	return sum(values) / float64(len(values))
+}
+
+// median computes the median without modifying the supplied values.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// percentile computes the p-th percentile (0-100) of sorted values, linearly interpolating
+// between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
@@ -0,0 +1,149 @@
+package analytics
+
+import (
+	"github.com/pkg/errors"
+)
+
+// CloudMaskSource defines the band used to identify cloudy pixels.
+type CloudMaskSource string
+
+const (
+	// CloudMaskNone disables cloud masking.
+	CloudMaskNone = "none"
+
+	// CloudMaskSCL masks pixels using the sentinel-2 L2A scene classification band.
+	CloudMaskSCL = "scl"
+
+	// CloudMaskQA60 masks pixels using the sentinel-2 QA60 cloud bitmask band.
+	CloudMaskQA60 = "qa60"
+
+	// sentinel-2 cloud mask bands
+	sclBand  = "SCL"
+	qa60Band = "QA60"
+
+	// name of the cloud fraction value
+	cloudFractionName = "cloud_fraction"
+
+	// QA60 bits flagging opaque clouds and cirrus
+	qa60OpaqueCloud = 1 << 10
+	qa60Cirrus      = 1 << 11
+)
+
+// Scene classification values excluded by the SCL cloud mask.
+var sclExcludedClasses = map[int]bool{
+	3:  true, // cloud shadows
+	8:  true, // cloud medium probability
+	9:  true, // cloud high probability
+	10: true, // thin cirrus
+	11: true, // snow / ice
+}
+
+// CloudMask wraps a tile analytic, marking cloud, cloud shadow, cirrus and snow pixels as invalid
+// before the analytic's transform is applied.  The fraction of masked pixels is reported as
+// an additional value.
+type CloudMask struct {
+	Transformer
+	Source CloudMaskSource
+}
+
+// NewCloudMask creates a new cloud mask stage for a tile analytic.
+func NewCloudMask(tileAnalytic Transformer, source CloudMaskSource) (*CloudMask, error) {
+	if source != CloudMaskSCL && source != CloudMaskQA60 {
+		return nil, errors.Errorf("unrecognized cloud mask source %s", source)
+	}
+	return &CloudMask{Transformer: tileAnalytic, Source: source}, nil
+}
+
+// Setup loads the data for the wrapped analytic along with the cloud mask band, and applies the
+// mask to copies of the analytic's images, since loaded images are shared between analytics.  The
+// cloud mask image is appended to the returned images.
+func (c CloudMask) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
+	images, err := c.Transformer.Setup(inputDir, tile)
+	if err != nil {
+		return nil, err
+	}
+
+	band := sclBand
+	if c.Source == CloudMaskQA60 {
+		band = qa60Band
+	}
+	loaded, err := loadBand(inputDir, tile, band)
+	if err != nil {
+		return nil, err
+	}
+	maskImage := *loaded
+	maskImage.Categorical = true
+
+	masked := make([]*GeoImage, len(images), len(images)+1)
+	for i, image := range images {
+		masked[i] = c.apply(&maskImage, image)
+	}
+	return append(masked, &maskImage), nil
+}
+
+// Transform applies the wrapped analytic's transform to the masked images, and appends the
+// fraction of the tile covered by cloud, shadow or snow.
+func (c CloudMask) Transform(tileData []*GeoImage) ([]float64, error) {
+	if len(tileData) < 2 {
+		return nil, errors.New("missing cloud mask data")
+	}
+	maskImage := tileData[len(tileData)-1]
+	values, err := c.Transformer.Transform(tileData[:len(tileData)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	numCloudy := 0
+	numPixels := 0
+	for i, value := range maskImage.Data {
+		if !maskImage.IsValid(i) {
+			continue
+		}
+		if c.isCloudy(value) {
+			numCloudy++
+		}
+		numPixels++
+	}
+
+	cloudFraction := 0.0
+	if numPixels > 0 {
+		cloudFraction = float64(numCloudy) / float64(numPixels)
+	}
+	return append(values, cloudFraction), nil
+}
+
+// ValueNames returns the wrapped analytic's value names followed by the cloud fraction.
+func (c CloudMask) ValueNames() []string {
+	return append(c.Transformer.ValueNames(), cloudFractionName)
+}
+
+// Returns true if the mask band value identifies a pixel that should be excluded.
+func (c CloudMask) isCloudy(value float64) bool {
+	if c.Source == CloudMaskQA60 {
+		return int(value)&(qa60OpaqueCloud|qa60Cirrus) != 0
+	}
+	return sclExcludedClasses[int(value)]
+}
+
+// Returns a copy of an image with the pixels that are cloudy in the mask image marked as invalid.  The
+// mask band may be at a coarser resolution than the image (ie. 20m SCL vs. 10m B04), so image pixels are
+// mapped to the mask pixel covering the same relative position in the tile.
+func (c CloudMask) apply(maskImage *GeoImage, image *GeoImage) *GeoImage {
+	masked := *image
+	masked.Valid = make([]bool, len(image.Data))
+	for i := range masked.Valid {
+		masked.Valid[i] = image.IsValid(i)
+	}
+
+	for y := 0; y < image.YSize; y++ {
+		maskY := y * maskImage.YSize / image.YSize
+		for x := 0; x < image.XSize; x++ {
+			maskX := x * maskImage.XSize / image.XSize
+			maskIndex := maskY*maskImage.XSize + maskX
+			if maskImage.IsValid(maskIndex) && c.isCloudy(maskImage.Data[maskIndex]) {
+				masked.Valid[y*image.XSize+x] = false
+			}
+		}
+	}
+	return &masked
+}
@@ -0,0 +1,25 @@
+package analytics
+
+import "testing"
+
+func TestCloudMaskApply(t *testing.T) {
+	c, err := NewCloudMask(MeanNDVI{}, CloudMaskSCL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a 2x2 image masked by a 1x2 band, with cloud (9) covering the bottom row
+	image := &GeoImage{Data: []float64{1, 2, 3, 4}, Valid: []bool{true, false, true, true}, XSize: 2, YSize: 2}
+	maskImage := &GeoImage{Data: []float64{4, 9}, XSize: 1, YSize: 2}
+
+	masked := c.apply(maskImage, image)
+	want := []bool{true, false, false, false}
+	for i := range want {
+		if masked.Valid[i] != want[i] {
+			t.Errorf("pixel %d valid %v, want %v", i, masked.Valid[i], want[i])
+		}
+	}
+	if masked == image || !image.Valid[2] || !image.Valid[3] {
+		t.Error("the shared image was modified")
+	}
+}
@@ -0,0 +1,454 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Expression evaluates a user supplied band math formula for each pixel in a tile, and
+// aggregates the results into a single value.  Band names referenced by the formula
+// (ie. `(B08-B04)/(B08+B04)`) are resolved to `<geohash>_<date>_<band>.tif` files.
+type Expression struct {
+	Formula     string
+	Aggregation Aggregation
+	Bands       []string
+	root        exprNode
+}
+
+// NewExpression parses a formula and creates a new expression operation.
+func NewExpression(formula string, aggregation Aggregation) (*Expression, error) {
+	if formula == "" {
+		return nil, errors.New("expression operation requires a formula")
+	}
+	if _, err := aggregate(nil, aggregation); err != nil {
+		return nil, err
+	}
+
+	p := exprParser{bandIndices: map[string]int{}}
+	if err := p.tokenize(formula); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse expression %s", formula)
+	}
+	root, err := p.parse()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse expression %s", formula)
+	}
+	if len(p.bands) == 0 {
+		return nil, errors.Errorf("expression %s does not reference any bands", formula)
+	}
+
+	return &Expression{
+		Formula:     formula,
+		Aggregation: aggregation,
+		Bands:       p.bands,
+		root:        root,
+	}, nil
+}
+
+// Setup loads each of the bands referenced by the expression.
+func (e Expression) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
+	return loadBands(inputDir, tile, e.Bands)
+}
+
+// Transform implements the expression tile transformation, which evaluates the formula for each
+// pixel and aggregates the results.  Invalid pixels, and pixels that evaluate to NaN or infinity
+// (ie. a zero denominator), are excluded from the aggregation.
+func (e Expression) Transform(tileData []*GeoImage) ([]float64, error) {
+	if len(tileData) != len(e.Bands) {
+		return nil, errors.Errorf("expected %d bands, received %d", len(e.Bands), len(tileData))
+	}
+	numPixels := len(tileData[0].Data)
+	for i, image := range tileData {
+		if len(image.Data) != numPixels {
+			return nil, errors.Errorf("band %s size %d does not match band %s size %d",
+				e.Bands[i], len(image.Data), e.Bands[0], numPixels)
+		}
+	}
+
+	pixel := make([]float64, len(tileData))
+	values := make([]float64, 0, numPixels)
+	for i := 0; i < numPixels; i++ {
+		if !isValidPixel(tileData, i) {
+			continue
+		}
+		for j, image := range tileData {
+			pixel[j] = image.Data[i]
+		}
+		value := e.root.eval(pixel)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		values = append(values, value)
+	}
+
+	result, err := aggregate(values, e.Aggregation)
+	if err != nil {
+		return nil, err
+	}
+	return []float64{result, validFraction(tileData)}, nil
+}
+
+// ValueNames returns the name of the aggregated expression value.
+func (e Expression) ValueNames() []string {
+	return []string{fmt.Sprintf("expression_%s", e.Aggregation), validFractionName}
+}
+
+// exprNode is a node in a parsed expression tree, evaluated against the band values
+// of a single pixel.
+type exprNode interface {
+	eval(pixel []float64) float64
+}
+
+type constNode float64
+
+func (n constNode) eval(pixel []float64) float64 {
+	return float64(n)
+}
+
+type bandNode int
+
+func (n bandNode) eval(pixel []float64) float64 {
+	return pixel[n]
+}
+
+type negateNode struct {
+	operand exprNode
+}
+
+func (n negateNode) eval(pixel []float64) float64 {
+	return -n.operand.eval(pixel)
+}
+
+type binaryNode struct {
+	op    rune
+	left  exprNode
+	right exprNode
+}
+
+func (n binaryNode) eval(pixel []float64) float64 {
+	left := n.left.eval(pixel)
+	right := n.right.eval(pixel)
+	switch n.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	case '/':
+		return left / right
+	default:
+		return math.Pow(left, right)
+	}
+}
+
+type funcNode struct {
+	fn   func(args []float64) float64
+	args []exprNode
+}
+
+func (n funcNode) eval(pixel []float64) float64 {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(pixel)
+	}
+	return n.fn(args)
+}
+
+// exprFunction defines a function that can be called from an expression.
+type exprFunction struct {
+	arity int
+	fn    func(args []float64) float64
+}
+
+var exprFunctions = map[string]exprFunction{
+	"abs":  {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"sqrt": {1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"exp":  {1, func(args []float64) float64 { return math.Exp(args[0]) }},
+	"log":  {1, func(args []float64) float64 { return math.Log(args[0]) }},
+	"min":  {2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+	"max":  {2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+}
+
+type exprTokenKind int
+
+const (
+	tokenNumber exprTokenKind = iota
+	tokenIdent
+	tokenOperator
+	tokenEnd
+)
+
+type exprToken struct {
+	kind  exprTokenKind
+	text  string
+	value float64
+}
+
+// exprParser is a recursive descent parser for band math expressions, supporting
+// +, -, *, /, ^, unary negation, parentheses and a small set of math functions.
+type exprParser struct {
+	tokens      []exprToken
+	pos         int
+	bands       []string
+	bandIndices map[string]int
+}
+
+func (p *exprParser) tokenize(formula string) error {
+	runes := []rune(formula)
+	for i := 0; i < len(runes); {
+		var err error
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case isNumberRune(r):
+			i, err = p.scanNumber(runes, i)
+		case isIdentStart(r):
+			i = p.scanIdent(runes, i)
+		default:
+			i, err = p.scanOperator(runes, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.tokens = append(p.tokens, exprToken{kind: tokenEnd})
+	return nil
+}
+
+// Scans the number starting at i, returning the position following it.
+func (p *exprParser) scanNumber(runes []rune, i int) (int, error) {
+	start := i
+	i = skipRunes(runes, i, isNumberRune)
+	// allow for exponents (ie. 1e-3)
+	if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		i++
+		if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+			i++
+		}
+		i = skipRunes(runes, i, unicode.IsDigit)
+	}
+	text := string(runes[start:i])
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return i, errors.Errorf("invalid number %s", text)
+	}
+	p.tokens = append(p.tokens, exprToken{kind: tokenNumber, text: text, value: value})
+	return i, nil
+}
+
+// Scans the band or function name starting at i, returning the position following it.
+func (p *exprParser) scanIdent(runes []rune, i int) int {
+	start := i
+	i = skipRunes(runes, i, isIdentRune)
+	p.tokens = append(p.tokens, exprToken{kind: tokenIdent, text: string(runes[start:i])})
+	return i
+}
+
+// Scans the single character operator or separator at i, returning the position following it.
+func (p *exprParser) scanOperator(runes []rune, i int) (int, error) {
+	if !strings.ContainsRune("+-*/^(),", runes[i]) {
+		return i, errors.Errorf("unexpected character '%c'", runes[i])
+	}
+	p.tokens = append(p.tokens, exprToken{kind: tokenOperator, text: string(runes[i])})
+	return i + 1, nil
+}
+
+// Returns the position of the first rune at or after i that doesn't match.
+func skipRunes(runes []rune, i int, match func(rune) bool) int {
+	for i < len(runes) && match(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func isNumberRune(r rune) bool {
+	return unicode.IsDigit(r) || r == '.'
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentRune(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *exprParser) isOperator(text string) bool {
+	token := p.peek()
+	return token.kind == tokenOperator && token.text == text
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.isOperator(text) {
+		return errors.Errorf("expected '%s'", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	node, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != tokenEnd {
+		return nil, errors.Errorf("unexpected '%s'", token.text)
+	}
+	return node, nil
+}
+
+// sum := product (('+' | '-') product)*
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("+") || p.isOperator("-") {
+		op := p.next().text
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: rune(op[0]), left: left, right: right}
+	}
+	return left, nil
+}
+
+// product := unary (('*' | '/') unary)*
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("*") || p.isOperator("/") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: rune(op[0]), left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary := ('-' | '+') unary | power
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOperator("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{operand}, nil
+	}
+	if p.isOperator("+") {
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// power := primary ('^' unary)?
+func (p *exprParser) parsePower() (exprNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOperator("^") {
+		p.next()
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: '^', left: base, right: exponent}, nil
+	}
+	return base, nil
+}
+
+// primary := number | band | function '(' sum (',' sum)* ')' | '(' sum ')'
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenNumber:
+		return constNode(token.value), nil
+	case tokenIdent:
+		if p.isOperator("(") {
+			return p.parseFunction(token.text)
+		}
+		return p.bandNode(token.text), nil
+	case tokenOperator:
+		if token.text == "(" {
+			node, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+		return nil, errors.Errorf("unexpected '%s'", token.text)
+	default:
+		return nil, errors.New("unexpected end of expression")
+	}
+}
+
+func (p *exprParser) parseFunction(name string) (exprNode, error) {
+	function, ok := exprFunctions[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.Errorf("unknown function %s", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	args := []exprNode{}
+	for {
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.isOperator(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if len(args) != function.arity {
+		return nil, errors.Errorf("function %s expects %d arguments, received %d", name, function.arity, len(args))
+	}
+	return funcNode{fn: function.fn, args: args}, nil
+}
+
+// bandNode returns a node referencing the named band, assigning the band an index in the
+// order it is first encountered.
+func (p *exprParser) bandNode(band string) exprNode {
+	index, ok := p.bandIndices[band]
+	if !ok {
+		index = len(p.bands)
+		p.bandIndices[band] = index
+		p.bands = append(p.bands, band)
+	}
+	return bandNode(index)
+}
@@ -0,0 +1,117 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExpressionEval(t *testing.T) {
+	tests := []struct {
+		formula string
+		bands   map[string]float64
+		want    float64
+	}{
+		{"1 + 2 * 3", nil, 7},
+		{"(1 + 2) * 3", nil, 9},
+		{"10 - 4 - 3", nil, 3},
+		{"24 / 4 / 2", nil, 3},
+		{"2 ^ 3 ^ 2", nil, 512},
+		{"(2 ^ 3) ^ 2", nil, 64},
+		{"-2 ^ 2", nil, -4},
+		{"2 ^ -1", nil, 0.5},
+		{"--3", nil, 3},
+		{"+3 - -3", nil, 6},
+		{"2 * -3", nil, -6},
+		{"1e-3 * 1E3", nil, 1},
+		{"abs(-2) + sqrt(16)", nil, 6},
+		{"min(3, 1) + MAX(3, 1)", nil, 4},
+		{"exp(log(5))", nil, 5},
+		{"(B08 - B04) / (B08 + B04)", map[string]float64{"B08": 0.6, "B04": 0.2}, 0.5},
+		{"B04 * B04 + B04", map[string]float64{"B04": 3}, 12},
+		{"(B08 - B04) / (B08 + B04 + 0.5) * 1.5", map[string]float64{"B08": 0.5, "B04": 0}, 0.75},
+	}
+
+	for _, test := range tests {
+		formula := test.formula
+		if test.bands == nil {
+			// expressions must reference a band
+			formula = "B01 * 0 + " + formula
+		}
+		e, err := NewExpression(formula, AggregationMean)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.formula, err)
+			continue
+		}
+		pixel := make([]float64, len(e.Bands))
+		for i, band := range e.Bands {
+			pixel[i] = test.bands[band]
+		}
+		if got := e.root.eval(pixel); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", test.formula, got, test.want)
+		}
+	}
+}
+
+func TestExpressionBands(t *testing.T) {
+	e, err := NewExpression("(B08 - B04) / (B08 + B04) + B11", AggregationMean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"B08", "B04", "B11"}
+	if len(e.Bands) != len(want) {
+		t.Fatalf("bands %v, want %v", e.Bands, want)
+	}
+	for i := range want {
+		if e.Bands[i] != want[i] {
+			t.Errorf("bands %v, want %v", e.Bands, want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1 + 2",
+		"B04 +",
+		"(B04 + B08",
+		"B04 + B08)",
+		"B04 B08",
+		"B04 * * B08",
+		"B04 $ B08",
+		"1.2.3 * B04",
+		"min(B04)",
+		"abs(B04, B08)",
+		"foo(B04)",
+		"abs B04",
+		"min(B04,)",
+	}
+	for _, formula := range tests {
+		if _, err := NewExpression(formula, AggregationMean); err == nil {
+			t.Errorf("%q: expected an error", formula)
+		}
+	}
+
+	if _, err := NewExpression("B04", Aggregation("mode")); err == nil {
+		t.Error("expected an error for an unknown aggregation")
+	}
+}
+
+func TestExpressionTransform(t *testing.T) {
+	e, err := NewExpression("B08 / B04", AggregationMean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []*GeoImage{
+		{Data: []float64{2, 4, 6, 8}, Valid: []bool{true, true, true, false}},
+		{Data: []float64{1, 2, 0, 1}},
+	}
+
+	// the third pixel divides by zero and the fourth is invalid, so only the first two are aggregated
+	values, err := e.Transform(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 2 || values[1] != 0.75 {
+		t.Errorf("values %v, want [2 0.75]", values)
+	}
+}
@@ -2,10 +2,29 @@ package analytics
 
 import (
 	"fmt"
+	"math"
+	"strings"
+	"unsafe"
 
 	"github.com/pkg/errors"
 	"github.com/uncharted-distil/gdal"
-	log "github.com/unchartedsoftware/plog"
+)
+
+// GDAL mask band flags (see GDALGetMaskFlags)
+const (
+	gdalMaskAllValid = 0x01
+	gdalMaskNoData   = 0x08
+)
+
+// proj4 definition of WGS84 longitude / latitude.  Unlike EPSG:4326, it keeps longitude as the first
+// axis when transforming.
+const wgs84Proj4 = "+proj=longlat +datum=WGS84 +no_defs"
+
+// GDAL data types added after the bindings were generated (see GDALDataType)
+const (
+	gdalUInt64 = gdal.DataType(12)
+	gdalInt64  = gdal.DataType(13)
+	gdalInt8   = gdal.DataType(14)
 )
 
 // GeoBounds defines a rectangular geographic boundary.
@@ -25,30 +44,88 @@ func (g GeoBounds) String() string {
 	)
 }
 
+// Ring returns the closed exterior ring of the boundary as counter-clockwise lon, lat pairs.
+func (g GeoBounds) Ring() [][2]float64 {
+	return [][2]float64{
+		{g.MinLon, g.MinLat},
+		{g.MaxLon, g.MinLat},
+		{g.MaxLon, g.MaxLat},
+		{g.MinLon, g.MaxLat},
+		{g.MinLon, g.MinLat},
+	}
+}
+
 // GeoImage is a gray16 image and its associated geobounds.
 type GeoImage struct {
 	Data   []float64
+	Valid  []bool
 	XSize  int
 	YSize  int
 	Bounds GeoBounds
+	// CRS identifies the coordinate system of the image (ie. EPSG:32633)
+	CRS string
+	// Scaled is set if the band's scale and offset have been applied to the data
+	Scaled bool
+	// Integer is set if the band stores integer values, such as digital numbers
+	Integer bool
+	// Categorical is set if the data are class values, which can't be interpolated
+	Categorical bool
+	// size of a pixel in the units of the image's coordinate system
+	PixelWidth  float64
+	PixelHeight float64
+}
+
+// IsValid returns false if the pixel at the given index is NoData or has been masked out.
+func (g *GeoImage) IsValid(index int) bool {
+	return g.Valid == nil || g.Valid[index]
+}
+
+// Returns true if the pixel at the given index is valid in all of the images.
+func isValidPixel(images []*GeoImage, index int) bool {
+	for _, image := range images {
+		if !image.IsValid(index) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of pixels that are valid in all of the images.
+func countValid(images []*GeoImage) int {
+	count := 0
+	for i := range images[0].Data {
+		if isValidPixel(images, i) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the fraction of pixels that are valid in all of the images.
+func validFraction(images []*GeoImage) float64 {
+	if len(images) == 0 || len(images[0].Data) == 0 {
+		return 0
+	}
+	return float64(countValid(images)) / float64(len(images[0].Data))
 }
 
-// Load a geotiff into a float64 buffer.  If the file contains more than one band, only the first will be used.
-func loadGeoImage(filePath string) (*GeoImage, error) {
+// Load bands of a geotiff into float64 buffers, opening the file once.  Bands are identified by their
+// 1-based index.  Pixels matching a band's NoData value or excluded by its mask band are flagged as invalid.
+// If applyScale is set, values are converted using the band's scale and offset when it has them.
+func loadGeoImages(filePath string, bandIndexes []int, applyScale bool) ([]*GeoImage, error) {
 	// Load each of the datasets
 	gdalDataset, err := gdal.Open(filePath, gdal.ReadOnly)
 	if err != nil {
 		return nil, errors.Wrap(err, "band file not loaded")
 	}
+	defer gdalDataset.Close() // done with GDAL buffer
 
-	// Accept a single band.
 	numBands := gdalDataset.RasterCount()
-	if numBands == 0 {
-		log.Warnf("found 0 bands - skipping")
-	} else if numBands > 1 {
-		log.Warnf("found %d bands - using band 0 only", numBands)
+	for _, index := range bandIndexes {
+		if index < 1 || index > numBands {
+			return nil, errors.Errorf("band %d requested from %s with %d bands", index, filePath, numBands)
+		}
 	}
-	inputBand := gdalDataset.RasterBand(1)
 
 	// extract input raster size and update max x,y
 	xSize := gdalDataset.RasterXSize()
@@ -56,53 +133,264 @@ func loadGeoImage(filePath string) (*GeoImage, error) {
 
 	// compute the geocoordinates
 	tx := gdalDataset.GeoTransform()
-	bounds := GeoBounds{
-		MinLon: tx[0],
-		MinLat: tx[3] + float64(xSize)*tx[4] + float64(ySize)*tx[5],
-		MaxLon: tx[0] + float64(xSize)*tx[1] + float64(ySize)*tx[2],
-		MaxLat: tx[3],
+	bounds, crs, err := computeBounds(tx, xSize, ySize, gdalDataset.Projection())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compute bounds of %s", filePath)
+	}
+
+	images := make([]*GeoImage, len(bandIndexes))
+	for i, index := range bandIndexes {
+		inputBand := gdalDataset.RasterBand(index)
+		bandData, valid, err := readBand(xSize, ySize, &inputBand)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load band %d of %s", index, filePath)
+		}
+		images[i] = &GeoImage{
+			Data:        bandData,
+			Valid:       valid,
+			XSize:       xSize,
+			YSize:       ySize,
+			Bounds:      bounds,
+			CRS:         crs,
+			Integer:     isIntegerType(inputBand.RasterDataType()),
+			PixelWidth:  math.Hypot(tx[1], tx[4]),
+			PixelHeight: math.Hypot(tx[2], tx[5])}
+		if applyScale {
+			images[i].Scaled = applyBandScale(bandData, &inputBand)
+		}
+	}
+	return images, nil
+}
+
+// Reads the descriptions of the bands of a geotiff, returning the 1-based index of each described band.
+// The first band is used if several have the same description.
+func readBandDescriptions(filePath string) (map[string]int, error) {
+	gdalDataset, err := gdal.Open(filePath, gdal.ReadOnly)
+	if err != nil {
+		return nil, errors.Wrap(err, "band file not loaded")
+	}
+	defer gdalDataset.Close()
+
+	descriptions := map[string]int{}
+	for index := 1; index <= gdalDataset.RasterCount(); index++ {
+		description := bandDescription(gdalDataset.RasterBand(index))
+		if _, ok := descriptions[description]; description != "" && !ok {
+			descriptions[description] = index
+		}
+	}
+	return descriptions, nil
+}
+
+// Returns the description of a band.  The bindings only expose descriptions on MajorObject, which wraps
+// a GDAL handle in the same way as a RasterBand.
+func bandDescription(band gdal.RasterBand) string {
+	return (*gdal.MajorObject)(unsafe.Pointer(&band)).Description()
+}
+
+// Computes the longitude / latitude bounding box of a raster by transforming its four corners from the
+// raster's coordinate system to WGS84, and returns an identifier for the coordinate system.  Rasters
+// without a coordinate system are assumed to be in longitude / latitude already.
+func computeBounds(tx [6]float64, xSize int, ySize int, projection string) (GeoBounds, string, error) {
+	// the corners are offset along both axes by the rotation terms of the geotransform
+	xs := make([]float64, 4)
+	ys := make([]float64, 4)
+	zs := make([]float64, 4)
+	corners := [][2]float64{{0, 0}, {float64(xSize), 0}, {0, float64(ySize)}, {float64(xSize), float64(ySize)}}
+	for i, corner := range corners {
+		xs[i] = tx[0] + corner[0]*tx[1] + corner[1]*tx[2]
+		ys[i] = tx[3] + corner[0]*tx[4] + corner[1]*tx[5]
+	}
+
+	crs := ""
+	if projection != "" {
+		source := gdal.CreateSpatialReference(projection)
+		defer source.Destroy()
+		crs = crsIdentifier(source)
+
+		// import the source through proj4 so that its axes are in easting / northing order
+		proj4, err := source.ToProj4()
+		if err != nil {
+			return GeoBounds{}, "", errors.Wrap(err, "failed to read coordinate system")
+		}
+		transformSource := gdal.CreateSpatialReference("")
+		defer transformSource.Destroy()
+		if err := transformSource.FromProj4(proj4); err != nil {
+			return GeoBounds{}, "", errors.Wrap(err, "failed to read coordinate system")
+		}
+		target := gdal.CreateSpatialReference("")
+		defer target.Destroy()
+		if err := target.FromProj4(wgs84Proj4); err != nil {
+			return GeoBounds{}, "", errors.Wrap(err, "failed to create WGS84 coordinate system")
+		}
+
+		transform := gdal.CreateCoordinateTransform(transformSource, target)
+		defer transform.Destroy()
+		if !transform.Transform(len(xs), xs, ys, zs) {
+			return GeoBounds{}, "", errors.Errorf("failed to transform corners from %s", crs)
+		}
 	}
 
+	bounds := GeoBounds{MinLon: xs[0], MinLat: ys[0], MaxLon: xs[0], MaxLat: ys[0]}
+	for i := 1; i < len(xs); i++ {
+		bounds.MinLon = math.Min(bounds.MinLon, xs[i])
+		bounds.MinLat = math.Min(bounds.MinLat, ys[i])
+		bounds.MaxLon = math.Max(bounds.MaxLon, xs[i])
+		bounds.MaxLat = math.Max(bounds.MaxLat, ys[i])
+	}
+	return bounds, crs, nil
+}
+
+// Returns the authority code of a coordinate system (ie. EPSG:32633), or its proj4 definition if it
+// can't be identified.
+func crsIdentifier(sr gdal.SpatialReference) string {
+	if sr.AuthorityCode("") == "" {
+		// identification is best effort - the proj4 definition is used if it fails
+		_ = sr.AutoIdentifyEPSG()
+	}
+	if name, code := sr.AuthorityName(""), sr.AuthorityCode(""); name != "" && code != "" {
+		return name + ":" + code
+	}
+	proj4, err := sr.ToProj4()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(proj4)
+}
+
+// Converts raw band values using the band's scale and offset, returning false if the band has neither.
+// The validity mask is read from the raw values first, since NoData values are not scaled.
+func applyBandScale(bandData []float64, inputBand *gdal.RasterBand) bool {
+	scale, hasScale := inputBand.GetScale()
+	offset, hasOffset := inputBand.GetOffset()
+	if !hasScale {
+		scale = 1
+	}
+	if !hasOffset {
+		offset = 0
+	}
+	if scale == 1 && offset == 0 {
+		return false
+	}
+	for i, value := range bandData {
+		bandData[i] = value*scale + offset
+	}
+	return true
+}
+
+// Returns true if a GDAL data type stores real integer values.
+func isIntegerType(dataType gdal.DataType) bool {
+	switch dataType {
+	case gdal.Byte, gdalInt8, gdal.UInt16, gdal.Int16, gdal.UInt32, gdal.Int32, gdalUInt64, gdalInt64:
+		return true
+	default:
+		return false
+	}
+}
+
+// bandReader reads the data of a band into a float64 array.
+type bandReader func(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error)
+
+// Readers for each of the supported GDAL data types.  Complex values are stored as their magnitude.
+var bandReaders = map[gdal.DataType]bandReader{
+	gdal.Byte:     readByteOrInt8,
+	gdalInt8:      readInt8,
+	gdal.UInt16:   readUint16,
+	gdal.Int16:    readInt16,
+	gdal.UInt32:   readUint32,
+	gdal.Int32:    readInt32,
+	gdalUInt64:    readUint64,
+	gdalInt64:     readInt64,
+	gdal.Float32:  readFloat32,
+	gdal.Float64:  readFloat64,
+	gdal.CInt16:   readComplexInt16,
+	gdal.CInt32:   readComplexInt32,
+	gdal.CFloat32: readComplexFloat32,
+	gdal.CFloat64: readComplexFloat64,
+}
+
+// Reads the data and validity mask of a band.
+func readBand(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, []bool, error) {
 	// extract input band data type
 	dataType := inputBand.RasterDataType()
 
 	// Read data in from tiff and save it out as a float64 array.  This is less efficient than storing
 	// each type nativel, but simplifies things downstream.
-	var bandData []float64
-	switch dataType {
-	case gdal.UInt16:
-		bandData, err = readUint16(xSize, ySize, &gdalDataset, &inputBand)
-	case gdal.Byte:
-		bandData, err = readByte(xSize, ySize, &gdalDataset, &inputBand)
-	case gdal.Float32:
-		bandData, err = readFloat32(xSize, ySize, &gdalDataset, &inputBand)
-	case gdal.Float64:
-		bandData, err = readFloat64(xSize, ySize, &gdalDataset, &inputBand)
-	default:
-		return nil, errors.Wrapf(err, "unhandled GDAL band type %v for %s", dataType, filePath)
+	reader, ok := bandReaders[dataType]
+	if !ok {
+		return nil, nil, errors.Errorf("unhandled GDAL band type %s", dataType.Name())
+	}
+	bandData, err := reader(xSize, ySize, inputBand)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load band data")
 	}
 
+	valid, err := readValidityMask(xSize, ySize, bandData, inputBand)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to load band data for %s", filePath)
+		return nil, nil, errors.Wrap(err, "failed to load band mask")
 	}
+	return bandData, valid, nil
+}
 
-	return &GeoImage{
-		Data:   bandData,
-		XSize:  xSize,
-		YSize:  ySize,
-		Bounds: bounds}, nil
+// Reads a byte band, which holds signed bytes if it was flagged as such before GDAL added the Int8 type.
+func readByteOrInt8(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	if inputBand.MetadataItem("PIXELTYPE", "IMAGE_STRUCTURE") == "SIGNEDBYTE" {
+		return readInt8(xSize, ySize, inputBand)
+	}
+	return readByte(xSize, ySize, inputBand)
+}
+
+// Builds the validity mask for a band from its NoData value and mask band.  Pixels that are NaN
+// are always considered invalid.  GDAL doesn't return the NoData value of 64-bit integer bands as a
+// double (it needs GDALGetRasterNoDataValueAsInt64, which the bindings don't expose), so the NoData pixels
+// of those bands are found using the mask band instead.
+func readValidityMask(xSize int, ySize int, bandData []float64, inputBand *gdal.RasterBand) ([]bool, error) {
+	dataType := inputBand.RasterDataType()
+	wideInteger := dataType == gdalInt64 || dataType == gdalUInt64
+
+	valid := make([]bool, len(bandData))
+	noDataValue, hasNoData := 0.0, false
+	if !wideInteger {
+		noDataValue, hasNoData = inputBand.NoDataValue()
+	}
+	for i, value := range bandData {
+		valid[i] = !math.IsNaN(value) && !(hasNoData && value == noDataValue)
+	}
+
+	// The mask band is only read when it carries information beyond the NoData value, such as
+	// an explicit per-dataset mask or an alpha band.
+	flags := inputBand.GetMaskFlags()
+	if flags&gdalMaskAllValid != 0 || (flags&gdalMaskNoData != 0 && !wideInteger) {
+		return valid, nil
+	}
+	if err := applyMaskBand(xSize, ySize, valid, inputBand); err != nil {
+		return nil, err
+	}
+	return valid, nil
+}
+
+// Flags the pixels excluded by the band's mask band as invalid.
+func applyMaskBand(xSize int, ySize int, valid []bool, inputBand *gdal.RasterBand) error {
+	maskBand := inputBand.GetMaskBand()
+	mask, err := readByte(xSize, ySize, &maskBand)
+	if err != nil {
+		return err
+	}
+	for i, value := range mask {
+		if value == 0 {
+			valid[i] = false
+		}
+	}
+	return nil
 }
 
 // If only there was some way you could make a function that took a type as an argument...
 
-func readByte(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.RasterBand) ([]float64, error) {
+func readByte(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
 	// read the band data into the image buffer
 	buffer := make([]byte, xSize*ySize)
 	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
-		dataset.Close()
 		return nil, err
 	}
-	dataset.Close() // done with GDAL buffer
 
 	// copy the data into the final float64 buffer
 	bandData := make([]float64, xSize*ySize)
@@ -113,14 +401,12 @@ func readByte(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.Raste
 	return bandData, nil
 }
 
-func readUint16(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.RasterBand) ([]float64, error) {
+func readUint16(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
 	// read the band data into the image buffer
 	buffer := make([]uint16, xSize*ySize)
 	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
-		dataset.Close()
 		return nil, err
 	}
-	dataset.Close() // done with GDAL buffer
 
 	// copy the data into the final float64 buffer
 	bandData := make([]float64, xSize*ySize)
@@ -131,14 +417,60 @@ func readUint16(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.Ras
 	return bandData, nil
 }
 
-func readFloat32(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.RasterBand) ([]float64, error) {
+func readInt16(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	// read the band data into the image buffer
+	buffer := make([]int16, xSize*ySize)
+	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
+		return nil, err
+	}
+
+	// copy the data into the final float64 buffer
+	bandData := make([]float64, xSize*ySize)
+	for i, val := range buffer {
+		bandData[i] = float64(val)
+	}
+
+	return bandData, nil
+}
+
+func readUint32(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	// read the band data into the image buffer
+	buffer := make([]uint32, xSize*ySize)
+	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
+		return nil, err
+	}
+
+	// copy the data into the final float64 buffer
+	bandData := make([]float64, xSize*ySize)
+	for i, val := range buffer {
+		bandData[i] = float64(val)
+	}
+
+	return bandData, nil
+}
+
+func readInt32(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	// read the band data into the image buffer
+	buffer := make([]int32, xSize*ySize)
+	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
+		return nil, err
+	}
+
+	// copy the data into the final float64 buffer
+	bandData := make([]float64, xSize*ySize)
+	for i, val := range buffer {
+		bandData[i] = float64(val)
+	}
+
+	return bandData, nil
+}
+
+func readFloat32(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
 	// read the band data into the image buffer
 	buffer := make([]float32, xSize*ySize)
 	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, buffer, xSize, ySize, 0, 0); err != nil {
-		dataset.Close()
 		return nil, err
 	}
-	dataset.Close() // done with GDAL buffer
 
 	// copy the data into the final float64 buffer
 	bandData := make([]float64, xSize*ySize)
@@ -149,13 +481,96 @@ func readFloat32(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.Ra
 	return bandData, nil
 }
 
-func readFloat64(xSize int, ySize int, dataset *gdal.Dataset, inputBand *gdal.RasterBand) ([]float64, error) {
+func readFloat64(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
 	// read the image into the band data buffer
 	bandData := make([]float64, xSize*ySize)
 	if err := inputBand.IO(gdal.Read, 0, 0, xSize, ySize, bandData, xSize, ySize, 0, 0); err != nil {
-		dataset.Close()
 		return nil, err
 	}
-	dataset.Close() // done with GDAL buffer
+	return bandData, nil
+}
+
+// The bindings can only read the byte, 16 and 32 bit integer and float types through RasterBand.IO, so
+// the remaining types are read in their native format a block at a time.
+
+func readInt8(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]int8, blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return float64(buffer[i])
+	})
+}
+
+func readUint64(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]uint64, blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return float64(buffer[i])
+	})
+}
+
+func readInt64(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]int64, blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return float64(buffer[i])
+	})
+}
+
+// Complex pixels are stored as interleaved real and imaginary parts.
+
+func readComplexInt16(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]int16, 2*blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return math.Hypot(float64(buffer[2*i]), float64(buffer[2*i+1]))
+	})
+}
+
+func readComplexInt32(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]int32, 2*blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return math.Hypot(float64(buffer[2*i]), float64(buffer[2*i+1]))
+	})
+}
+
+func readComplexFloat32(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]float32, 2*blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return math.Hypot(float64(buffer[2*i]), float64(buffer[2*i+1]))
+	})
+}
+
+func readComplexFloat64(xSize int, ySize int, inputBand *gdal.RasterBand) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	buffer := make([]float64, 2*blockXSize*blockYSize)
+	return readBlocks(xSize, ySize, inputBand, unsafe.Pointer(&buffer[0]), func(i int) float64 {
+		return math.Hypot(buffer[2*i], buffer[2*i+1])
+	})
+}
+
+// Reads each block of a band into the block buffer, and copies the pixels that lie within the raster
+// into a float64 buffer.  The value function converts the pixel at an index within the block buffer.
+func readBlocks(xSize int, ySize int, inputBand *gdal.RasterBand, blockBuffer unsafe.Pointer,
+	value func(i int) float64) ([]float64, error) {
+	blockXSize, blockYSize := inputBand.BlockSize()
+	bandData := make([]float64, xSize*ySize)
+	for blockY := 0; blockY*blockYSize < ySize; blockY++ {
+		for blockX := 0; blockX*blockXSize < xSize; blockX++ {
+			if err := inputBand.ReadBlock(blockX, blockY, blockBuffer); err != nil {
+				return nil, err
+			}
+
+			// edge blocks extend past the raster
+			for y := 0; y < blockYSize && blockY*blockYSize+y < ySize; y++ {
+				row := (blockY*blockYSize + y) * xSize
+				for x := 0; x < blockXSize && blockX*blockXSize+x < xSize; x++ {
+					bandData[row+blockX*blockXSize+x] = value(y*blockXSize + x)
+				}
+			}
+		}
+	}
 	return bandData, nil
 }
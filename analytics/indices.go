@@ -0,0 +1,220 @@
+package analytics
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// OperationMeanNDWI computes the mean normalized difference water index for a tile.
+	OperationMeanNDWI = "mean_ndwi"
+
+	// OperationMeanMNDWI computes the mean modified normalized difference water index for a tile.
+	OperationMeanMNDWI = "mean_mndwi"
+
+	// OperationMeanNDBI computes the mean normalized difference built-up index for a tile.
+	OperationMeanNDBI = "mean_ndbi"
+
+	// OperationMeanNBR computes the mean normalized burn ratio for a tile.
+	OperationMeanNBR = "mean_nbr"
+
+	// OperationMeanNDSI computes the mean normalized difference snow index for a tile.
+	OperationMeanNDSI = "mean_ndsi"
+
+	// OperationMeanEVI computes the mean enhanced vegetation index for a tile.
+	OperationMeanEVI = "mean_evi"
+
+	// OperationMeanSAVI computes the mean soil adjusted vegetation index for a tile.
+	OperationMeanSAVI = "mean_savi"
+
+	// additional sentinel constants
+	band2  = "B02"
+	band3  = "B03"
+	band11 = "B11"
+	band12 = "B12"
+
+	// sentinel-2 digital numbers are scaled reflectance values
+	sentinelQuantificationValue = 10000.0
+
+	// offset added to sentinel-2 L2A digital numbers from processing baseline 04.00
+	sentinelBaselineOffset = 1000.0
+
+	// soil brightness correction factor used by SAVI
+	saviSoilFactor = 0.5
+)
+
+// Date from which sentinel-2 products were generated with processing baseline 04.00.
+var sentinelBaselineOffsetDate = time.Date(2022, time.January, 25, 0, 0, 0, 0, time.UTC)
+
+// spectralIndices maps index operations to the index computation and the sentinel-2 bands
+// it requires.
+var spectralIndices = map[Operation]MeanSpectralIndex{
+	OperationMeanNDWI: {
+		Name:        OperationMeanNDWI,
+		description: "Mean normalized difference water index",
+		Bands:       []string{band3, band8},
+		index:       func(b []float64) float64 { return normalizedDifference(b[0], b[1]) },
+	},
+	OperationMeanMNDWI: {
+		Name:        OperationMeanMNDWI,
+		description: "Mean modified normalized difference water index",
+		Bands:       []string{band3, band11},
+		index:       func(b []float64) float64 { return normalizedDifference(b[0], b[1]) },
+	},
+	OperationMeanNDBI: {
+		Name:        OperationMeanNDBI,
+		description: "Mean normalized difference built-up index",
+		Bands:       []string{band11, band8},
+		index:       func(b []float64) float64 { return normalizedDifference(b[0], b[1]) },
+	},
+	OperationMeanNBR: {
+		Name:        OperationMeanNBR,
+		description: "Mean normalized burn ratio",
+		Bands:       []string{band8, band12},
+		index:       func(b []float64) float64 { return normalizedDifference(b[0], b[1]) },
+	},
+	OperationMeanNDSI: {
+		Name:        OperationMeanNDSI,
+		description: "Mean normalized difference snow index",
+		Bands:       []string{band3, band11},
+		index:       func(b []float64) float64 { return normalizedDifference(b[0], b[1]) },
+	},
+	OperationMeanEVI: {
+		Name:        OperationMeanEVI,
+		description: "Mean enhanced vegetation index",
+		Bands:       []string{band8, band4, band2},
+		index:       evi,
+	},
+	OperationMeanSAVI: {
+		Name:        OperationMeanSAVI,
+		description: "Mean soil adjusted vegetation index",
+		Bands:       []string{band8, band4},
+		index:       savi,
+	},
+}
+
+// MeanSpectralIndex computes the mean of a per-pixel spectral index for sentinel-2 tiles.
+type MeanSpectralIndex struct {
+	Name        string
+	Bands       []string
+	description string
+	index       func(bands []float64) float64
+}
+
+// NewMeanSpectralIndex creates a new spectral index operation for one of the index operation types.
+func NewMeanSpectralIndex(operation Operation) (*MeanSpectralIndex, error) {
+	index, ok := spectralIndices[operation]
+	if !ok {
+		return nil, errors.Errorf("unrecognized spectral index %s", operation)
+	}
+	return &index, nil
+}
+
+// Setup loads the bands required by the spectral index.
+func (m MeanSpectralIndex) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
+	return loadReflectance(inputDir, tile, m.Bands)
+}
+
+// Transform implements the spectral index tile transformation, which computes the average index
+// value for a given tile.  Pixels that are invalid in any band are skipped.
+func (m MeanSpectralIndex) Transform(tileData []*GeoImage) ([]float64, error) {
+	if len(tileData) != len(m.Bands) {
+		return nil, errors.Errorf("expected %d bands, received %d", len(m.Bands), len(tileData))
+	}
+	numPixels := len(tileData[0].Data)
+	for i, image := range tileData {
+		if len(image.Data) != numPixels {
+			return nil, errors.Errorf("band %s size %d does not match band %s size %d",
+				m.Bands[i], len(image.Data), m.Bands[0], numPixels)
+		}
+	}
+
+	sumIndex := 0.0
+	numValues := 0
+	pixel := make([]float64, len(tileData))
+	for i := 0; i < numPixels; i++ {
+		if !isValidPixel(tileData, i) {
+			continue
+		}
+		for j, image := range tileData {
+			pixel[j] = image.Data[i]
+		}
+		sumIndex += m.index(pixel)
+		numValues++
+	}
+
+	// compute the mean index
+	return []float64{sumIndex / float64(numValues), validFraction(tileData)}, nil
+}
+
+// ValueNames returns the name of the mean index value.
+func (m MeanSpectralIndex) ValueNames() []string {
+	return []string{m.Name, validFractionName}
+}
+
+// normalizedDifference computes (a - b) / (a + b), returning 0 when the denominator is 0 to
+// match the NDVI handling of empty pixels.
+func normalizedDifference(a float64, b float64) float64 {
+	if a+b == 0 {
+		return 0
+	}
+	return (a - b) / (a + b)
+}
+
+// evi computes the enhanced vegetation index from NIR, red and blue reflectance.
+func evi(b []float64) float64 {
+	nir, red, blue := b[0], b[1], b[2]
+	denominator := nir + 6*red - 7.5*blue + 1
+	if (b[0] == 0 && b[1] == 0 && b[2] == 0) || denominator == 0 {
+		return 0
+	}
+	return 2.5 * (nir - red) / denominator
+}
+
+// savi computes the soil adjusted vegetation index from NIR and red reflectance.
+func savi(b []float64) float64 {
+	nir, red := b[0], b[1]
+	if b[0] == 0 && b[1] == 0 {
+		return 0
+	}
+	return (1 + saviSoilFactor) * (nir - red) / (nir + red + saviSoilFactor)
+}
+
+// Loads sentinel-2 bands for a tile as surface reflectance.  Bands that have had their scale and offset
+// applied, or that store floating point values, are used as is.  Otherwise digital numbers are converted,
+// removing the offset added from processing baseline 04.00 so that values are comparable across years unless
+// the input has been harmonized.  Zero digital numbers mark empty pixels and are left as zero, and digital
+// numbers below the offset are clamped to zero reflectance.  No conversion is done for raw values.
+func loadReflectance(inputDir string, tile *Tile, bands []string) ([]*GeoImage, error) {
+	images, err := loadBands(inputDir, tile, bands)
+	if err != nil {
+		return nil, err
+	}
+	if tile.Input.RawValues {
+		return images, nil
+	}
+
+	offset := 0.0
+	if !tile.Input.Harmonized && tile.Timestamp >= sentinelBaselineOffsetDate.Unix() {
+		offset = sentinelBaselineOffset
+	}
+	for i, image := range images {
+		if image.Scaled || !image.Integer {
+			continue
+		}
+
+		// loaded images are shared between analytics, so convert a copy
+		reflectance := *image
+		reflectance.Data = make([]float64, len(image.Data))
+		for j, value := range image.Data {
+			if value != 0 {
+				reflectance.Data[j] = math.Max(0, value-offset) / sentinelQuantificationValue
+			}
+		}
+		reflectance.Scaled = true
+		images[i] = &reflectance
+	}
+	return images, nil
+}
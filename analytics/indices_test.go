@@ -0,0 +1,53 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoadReflectance(t *testing.T) {
+	before := sentinelBaselineOffsetDate.Unix() - 1
+	after := sentinelBaselineOffsetDate.Unix()
+	digitalNumbers := []float64{0, 500, 1000, 3000}
+	tests := []struct {
+		name      string
+		timestamp int64
+		input     InputOptions
+		image     GeoImage
+		want      []float64
+	}{
+		{"before the baseline change", before, InputOptions{}, GeoImage{Integer: true},
+			[]float64{0, 0.05, 0.1, 0.3}},
+		{"after the baseline change", after, InputOptions{}, GeoImage{Integer: true},
+			[]float64{0, 0, 0, 0.2}},
+		{"harmonized", after, InputOptions{Harmonized: true}, GeoImage{Integer: true},
+			[]float64{0, 0.05, 0.1, 0.3}},
+		{"raw values", after, InputOptions{RawValues: true}, GeoImage{Integer: true}, digitalNumbers},
+		{"floating point", after, InputOptions{}, GeoImage{}, digitalNumbers},
+		{"scaled", after, InputOptions{}, GeoImage{Integer: true, Scaled: true}, digitalNumbers},
+	}
+
+	for _, test := range tests {
+		loaded := test.image
+		loaded.Data = append([]float64{}, digitalNumbers...)
+		loaded.XSize, loaded.YSize = len(digitalNumbers), 1
+		tile := loadedTile("/tiles", "20220101T103000", test.timestamp, map[string]*GeoImage{"B04": &loaded})
+		tile.Input = test.input
+
+		images, err := loadReflectance("/tiles", tile, []string{"B04"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range test.want {
+			if math.Abs(images[0].Data[i]-want) > 1e-9 {
+				t.Errorf("%s: pixel %d = %v, want %v", test.name, i, images[0].Data[i], want)
+			}
+		}
+		for i, value := range loaded.Data {
+			if value != digitalNumbers[i] {
+				t.Errorf("%s: the shared image was modified", test.name)
+				break
+			}
+		}
+	}
+}
@@ -3,12 +3,11 @@ package analytics
 import (
 	"fmt"
 	"math"
-	"os"
 	"path"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
-	log "github.com/unchartedsoftware/plog"
 )
 
 // Operation defines the type of the operation specifier
@@ -35,6 +34,10 @@ const (
 	// OperationMean computes the mean for a tile.
 	OperationMean = "mean"
 
+	// OperationExpression evaluates a band math expression for each pixel in a tile and aggregates
+	// the results.
+	OperationExpression = "expression"
+
 	// Constants for data sources
 	// TODO: these should really be part of some configuration
 	// file that is supplied and updated as new datasource are included.
@@ -47,6 +50,9 @@ const (
 	discreteLandCoverBand           = "discrete_classification"
 	discreteLandCoverCategoryValues = "discrete_classification_class_values"
 	discreteLandCoverCategoryNames  = "discrete_classification_class_names"
+
+	// name of the valid pixel fraction value reported by each operation
+	validFractionName = "valid_fraction"
 )
 
 // Tile is structure that provides geospatial tile information.
@@ -54,101 +60,330 @@ type Tile struct {
 	GeoHash   string
 	Date      string
 	Timestamp int64
+	// Input controls how the tile's band files are read
+	Input InputOptions
+
+	// band images loaded for the tile, keyed by file path and band index, when shared by several analytics
+	images map[string]*GeoImage
+	// indexes of the described bands of the tile's stacked file, keyed by file path and description
+	descriptions map[string]map[string]int
+}
+
+// TileValues are the values generated for a single tile, along with its geographic bounds.  Err
+// is set if the values could not be generated.
+type TileValues struct {
+	Tile   Tile
+	Bounds GeoBounds
+	// CRS identifies the coordinate system of the tile's data
+	CRS    string
+	Values []float64
+	Err    error
+}
+
+const (
+	// StageSetup identifies errors raised while loading tile data.
+	StageSetup = "setup"
+
+	// StageTransform identifies errors raised while transforming tile data.
+	StageTransform = "transform"
+)
+
+// TileError is returned when an analytic fails to generate values for a tile.
+type TileError struct {
+	Stage string
+	Err   error
+}
+
+func (e *TileError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *TileError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error.
+func (e *TileError) Unwrap() error {
+	return e.Err
+}
+
+// FileError is returned when the file for one of a tile's bands can't be loaded.
+type FileError struct {
+	Band string
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s file not loaded: %v", e.Band, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *FileError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error.
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Options provides operation specific parameters supplied at runtime.
+type Options struct {
+	// Expression is the band math formula evaluated by the expression operation.
+	Expression string
+	// Aggregation is the method used to reduce per-pixel expression values to a tile value.
+	Aggregation Aggregation
+	// Percentiles are the percentiles (0-100) computed by the stats operation.
+	Percentiles []float64
+	// CloudMask is the band used to mask cloudy pixels before the operation is applied.
+	CloudMask CloudMaskSource
+	// Source is the per-tile operation that temporal operations are applied to.
+	Source Operation
+}
+
+// Analytic is an interface implemented by all tile operations.
+type Analytic interface {
+	ValueNames() []string
 }
 
 // Transformer in an interface that defines an operation on tile data.
 type Transformer interface {
+	Analytic
 	Setup(inputDir string, tile *Tile) ([]*GeoImage, error)
 	Transform(tileData []*GeoImage) ([]float64, error)
-	ValueNames() []string
 }
 
 // CreateTileAnalytic creates and initializes a tile analytic based on a requested operation
-// type.
-func CreateTileAnalytic(metadata JSONString, operation Operation) (Transformer, error) {
-	var tileAnalytic Transformer
-	var err error
-	if operation == OperationCategoryCountsRaw {
-		tileAnalytic, err = NewCategoryCountsRaw(metadata)
+// type, using the factory registered for the operation.  Temporal operations return a
+// TemporalTransformer, all others return a Transformer.  An error is returned if no factory is
+// registered for the operation.
+func CreateTileAnalytic(metadata JSONString, operation Operation, options Options) (Analytic, error) {
+	factory, ok := Lookup(operation)
+	if !ok {
+		return nil, errors.Errorf("unrecognized operation %s", operation)
+	}
+
+	tileAnalytic, err := factory.New(metadata, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// mask out cloudy pixels before a per-tile analytic is applied
+	if transformer, ok := tileAnalytic.(Transformer); ok && options.CloudMask != "" && options.CloudMask != CloudMaskNone {
+		tileAnalytic, err = NewCloudMask(transformer, options.CloudMask)
 		if err != nil {
 			return nil, err
 		}
-	} else if operation == OperationCategoryCountsPercentage {
-		tileAnalytic, err = NewCategoryCountsPercentage(metadata)
+	}
+	return tileAnalytic, nil
+}
+
+// Apply runs the setup and transform of one or more per-tile analytics on a tile, returning their
+// concatenated values along with the tile bounds.  Each band image is loaded at most once and shared
+// between the analytics, so transforms must not modify their images.  Images of different sizes loaded
+// for an analytic are resampled to a common grid before its transform.  On failure, Err is set to a
+// *TileError.
+func Apply(inputDir string, tile Tile, tileAnalytics ...Transformer) TileValues {
+	tile.images = map[string]*GeoImage{}
+	tile.descriptions = map[string]map[string]int{}
+	result := TileValues{Tile: tile}
+	result.Tile.images = nil
+	result.Tile.descriptions = nil
+
+	for i, tileAnalytic := range tileAnalytics {
+		images, err := tileAnalytic.Setup(inputDir, &tile)
+		if err == nil {
+			images, err = resampleImages(images, tile.Input.Resampling, tile.Input.Resolution)
+		}
 		if err != nil {
-			return nil, err
+			result.Err = &TileError{Stage: StageSetup, Err: err}
+			return result
 		}
-	} else if operation == OperationCategoryBinary {
-		tileAnalytic, err = NewCategoryBinary(metadata)
+		values, err := tileAnalytic.Transform(images)
 		if err != nil {
-			return nil, err
+			result.Err = &TileError{Stage: StageTransform, Err: err}
+			return result
+		}
+
+		// Extract the geobounds from the first image
+		if i == 0 {
+			result.Bounds = images[0].Bounds
+			result.CRS = images[0].CRS
+		}
+		result.Values = append(result.Values, values...)
+	}
+	return result
+}
+
+// InputOptions controls how the band files of a tile are read.
+type InputOptions struct {
+	// Stack is set if the tile's bands are stored in a single multi-band file.
+	Stack *BandStack
+	// RawValues disables applying the scale and offset of each band to its values, and converting
+	// sentinel-2 digital numbers to reflectance.
+	RawValues bool
+	// Harmonized is set if the processing baseline offset has already been removed from sentinel-2
+	// digital numbers.
+	Harmonized bool
+	// Resampling is the method used to resample the bands loaded for an analytic to a common grid.
+	Resampling Resampling
+	// Resolution is the pixel size of the common grid.  The grid of the finest band is used if it is 0.
+	Resolution float64
+}
+
+// BandStack describes a multi-band `<geohash>_<date>_<name>.tif` file that holds the bands of a tile,
+// rather than a file per band.
+type BandStack struct {
+	Name string
+	// Bands maps band names to their 1-based index in the stacked file.  Bands can also be referenced
+	// by index directly, or by their description in the file.
+	Bands map[string]int
+}
+
+// Returns the index of a band within the stacked file from the band map or the band name, or 0 if the
+// band has to be found by its description.
+func (s *BandStack) bandIndex(band string) int {
+	if index, ok := s.Bands[band]; ok {
+		return index
+	}
+	index, err := strconv.Atoi(band)
+	if err != nil || index < 1 {
+		return 0
+	}
+	return index
+}
+
+// Returns the path of the file containing a band of the tile, and the index of the band within it.
+func bandFile(inputDir string, tile *Tile, band string) (string, int, error) {
+	stack := tile.Input.Stack
+	if stack == nil {
+		return path.Join(inputDir, fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, band)), 1, nil
+	}
+	filePath := path.Join(inputDir, fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, stack.Name))
+	index := stack.bandIndex(band)
+	if index > 0 {
+		return filePath, index, nil
+	}
+
+	// find the band by its description, reading the descriptions once per tile
+	descriptions, ok := tile.descriptions[filePath]
+	if !ok {
+		var err error
+		descriptions, err = readBandDescriptions(filePath)
+		if err != nil {
+			return filePath, 0, err
 		}
-	} else if operation == OperationMeanNDVI {
-		tileAnalytic = MeanNDVI{}
-	} else if operation == OperationMean {
-		tileAnalytic, err = NewMean(metadata)
+		if tile.descriptions != nil {
+			tile.descriptions[filePath] = descriptions
+		}
+	}
+	index, ok = descriptions[band]
+	if !ok {
+		return filePath, 0, errors.Errorf("band %s not found in %s stack", band, stack.Name)
+	}
+	return filePath, index, nil
+}
+
+// Loads a band for a tile, reusing the image if it has already been loaded for the tile by another
+// analytic.
+func loadBand(inputDir string, tile *Tile, band string) (*GeoImage, error) {
+	images, err := loadBands(inputDir, tile, []string{band})
+	if err != nil {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+// Loads bands for a tile from their `<geohash>_<date>_<band>.tif` files, or from the tile's stacked
+// file if it has one.  The bands that are stored in the same file are read with a single open, and
+// images that have already been loaded for the tile by another analytic are reused.
+func loadBands(inputDir string, tile *Tile, bands []string) ([]*GeoImage, error) {
+	images := make([]*GeoImage, len(bands))
+
+	// group the bands that haven't been loaded by file
+	filePaths := []string{}
+	fileBands := map[string][]int{}
+	indexes := make([]int, len(bands))
+	for i, band := range bands {
+		filePath, index, err := bandFile(inputDir, tile, band)
 		if err != nil {
-			return nil, err
+			return nil, &FileError{Band: band, Path: filePath, Err: err}
 		}
-	} else {
-		log.Warnf("unrecognized operation - defaulting to %s", OperationMeanNDVI)
-		tileAnalytic = MeanNDVI{}
+		indexes[i] = index
+		if image, ok := tile.images[imageKey(filePath, index)]; ok {
+			images[i] = image
+			continue
+		}
+		if _, ok := fileBands[filePath]; !ok {
+			filePaths = append(filePaths, filePath)
+		}
+		fileBands[filePath] = append(fileBands[filePath], i)
 	}
-	return tileAnalytic, nil
+
+	for _, filePath := range filePaths {
+		fileIndexes := make([]int, len(fileBands[filePath]))
+		for j, i := range fileBands[filePath] {
+			fileIndexes[j] = indexes[i]
+		}
+		loaded, err := loadGeoImages(filePath, fileIndexes, !tile.Input.RawValues)
+		if err != nil {
+			return nil, &FileError{Band: bands[fileBands[filePath][0]], Path: filePath, Err: err}
+		}
+		for j, i := range fileBands[filePath] {
+			images[i] = loaded[j]
+			if tile.images != nil {
+				tile.images[imageKey(filePath, indexes[i])] = loaded[j]
+			}
+		}
+	}
+	return images, nil
+}
+
+// Returns the key of a band image in the tile image cache.
+func imageKey(filePath string, index int) string {
+	return fmt.Sprintf("%s:%d", filePath, index)
 }
 
 // MeanNDVI domputes mean NDVI for sentinel-2 tiles
 type MeanNDVI struct{}
 
 // Transform implements the MeanNDVI tile transformation, which computes the average NDVI for a given tile.
+// Pixels that are invalid in either band are skipped.
 func (m MeanNDVI) Transform(tileData []*GeoImage) ([]float64, error) {
 	sumNDVI := 0.0
 	numValues := 0
 	image0 := tileData[0].Data
 	image1 := tileData[1].Data
 	for i := range image0 {
-		// extract the 16 bit pixel values for each input band
+		if !isValidPixel(tileData, i) {
+			continue
+		}
+
+		// extract the reflectance values for each input band
 		value0 := image0[i]
 		value1 := image1[i]
 
 		// compute NDVI ratio
-		transformedValue := 0.0
-		if value0 != 0 || value1 != 0 {
-			transformedValue = math.Max(0, float64(int32(value0)-int32(value1))/float64(int32(value0)+int32(value1)))
-		}
+		transformedValue := math.Max(0, normalizedDifference(value0, value1))
 		sumNDVI += transformedValue
 		numValues++
 	}
 
 	// compute the mean NDVI
 	mean := sumNDVI / float64(numValues)
-	return []float64{mean}, nil
+	return []float64{mean, validFraction(tileData)}, nil
 }
 
-// Setup loads the data for the MeanNDVI tile transformation.
+// Setup loads the reflectance data for the MeanNDVI tile transformation.
 func (m MeanNDVI) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
-	band8FileName := fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, band8)
-	band8Path := path.Join(inputDir, band8FileName)
-	band8Image, err := loadGeoImage(band8Path)
-	if err != nil {
-		log.Error(err, "band 8 file not loaded")
-		os.Exit(1)
-	}
-
-	band4FileName := fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, band4)
-	band4Path := path.Join(inputDir, band4FileName)
-	band4Image, err := loadGeoImage(band4Path)
-	if err != nil {
-		log.Error(err, "band 4 file not loaded")
-		os.Exit(1)
-	}
-
-	return []*GeoImage{band8Image, band4Image}, nil
+	return loadReflectance(inputDir, tile, []string{band8, band4})
 }
 
 // ValueNames returns the name of the Mean NDVI value.
 func (m MeanNDVI) ValueNames() []string {
-	return []string{"mean_ndvi"}
+	return []string{"mean_ndvi", validFractionName}
 }
 
 // Mean computes mean for a single band tile
@@ -168,34 +403,37 @@ func NewMean(metadata JSONString) (*Mean, error) {
 }
 
 // Transform implements the mean tile transformation, which computes the average value for a given tile.
+// Invalid pixels are skipped.
 func (m Mean) Transform(tileData []*GeoImage) ([]float64, error) {
 	sum := 0.0
+	numValues := 0
 	data := tileData[0].Data
 	for i := range data {
+		if !tileData[0].IsValid(i) {
+			continue
+		}
 		// extract the 16 bit pixel values for each input band
 		sum += data[i]
+		numValues++
 	}
 
 	// compute the mean NDVI
-	mean := sum / float64(len(data))
-	return []float64{mean}, nil
+	mean := sum / float64(numValues)
+	return []float64{mean, validFraction(tileData)}, nil
 }
 
 // Setup loads the data for the MeanNDVI tile transformation.
 func (m Mean) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
-	fileName := fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, m.ColumnName)
-	path := path.Join(inputDir, fileName)
-	image, err := loadGeoImage(path)
+	image, err := loadBand(inputDir, tile, m.ColumnName)
 	if err != nil {
-		log.Error(err, "mean file not loaded")
-		os.Exit(1)
+		return nil, err
 	}
 	return []*GeoImage{image}, nil
 }
 
 // ValueNames returns the name of the Mean NDVI value.
 func (m Mean) ValueNames() []string {
-	return []string{m.ColumnName}
+	return []string{m.ColumnName, validFractionName}
 }
 
 // CategoryData provides a category label and its associated numeric value
@@ -244,14 +482,14 @@ func NewCategoryCounts(metadata JSONString) (CategoryCounts, error) {
 func (c CategoryCounts) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
 	// CDB: the band is hard coded to  land cover - it needs to be part of a configuration
 	// supplied at runtime
-	fileName := fmt.Sprintf("%s_%s_%s.tif", tile.GeoHash, tile.Date, discreteLandCoverBand)
-	path := path.Join(inputDir, fileName)
-	img, err := loadGeoImage(path)
+	loaded, err := loadBand(inputDir, tile, discreteLandCoverBand)
 	if err != nil {
-		log.Error(err, "channel file not loaded")
-		os.Exit(1)
+		return nil, err
 	}
-	return []*GeoImage{img}, nil
+	// the loaded image may be shared with other analytics, so it is copied before being marked
+	img := *loaded
+	img.Categorical = true
+	return []*GeoImage{&img}, nil
 }
 
 // ValueNames returns the names of the values in the same order as they are returned by the
@@ -261,7 +499,7 @@ func (c CategoryCounts) ValueNames() []string {
 	for i, category := range c.Categories {
 		valueNames[i] = category.Label
 	}
-	return valueNames
+	return append(valueNames, validFractionName)
 }
 
 func getCategoryValues(categoryValuesProperty string, metadata JSONString) ([]uint16, error) {
@@ -314,7 +552,11 @@ func NewCategoryCountsRaw(metadata JSONString) (CategoryCountsRaw, error) {
 // Transform implements the CategoryCounts tile transformation, which counts the number
 // of pixels of each category.
 func (c CategoryCountsRaw) Transform(tileData []*GeoImage) ([]float64, error) {
-	return computeCounts(&c.CategoryCounts, tileData)
+	counts, err := computeCounts(&c.CategoryCounts, tileData)
+	if err != nil {
+		return counts, err
+	}
+	return append(counts, validFraction(tileData)), nil
 }
 
 // CategoryCountsPercentage computes the percentage of pixels in a given tile the are assigned
@@ -341,14 +583,14 @@ func (c CategoryCountsPercentage) Transform(tileData []*GeoImage) ([]float64, er
 		return counts, err
 	}
 
-	// compute percentage in place
-	totalPixels := float64(tileData[0].XSize * tileData[0].YSize)
+	// compute percentage of the valid pixels in place
+	totalPixels := float64(countValid(tileData))
 	for i, count := range counts {
 		counts[i] = count / totalPixels
 	}
 
 	// compute each as a percentage of the total
-	return counts, nil
+	return append(counts, validFraction(tileData)), nil
 }
 
 // CategoryBinary sets a value of 1 if a particular category is present for a tile, 0
@@ -383,7 +625,7 @@ func (c CategoryBinary) Transform(tileData []*GeoImage) ([]float64, error) {
 			counts[i] = 0.0
 		}
 	}
-	return counts, nil
+	return append(counts, validFraction(tileData)), nil
 }
 
 func computeCounts(c *CategoryCounts, tileData []*GeoImage) ([]float64, error) {
@@ -392,7 +634,11 @@ func computeCounts(c *CategoryCounts, tileData []*GeoImage) ([]float64, error) {
 	}
 
 	categoryCounts := make([]float64, len(c.Categories))
-	for _, val := range tileData[0].Data {
+	for i, val := range tileData[0].Data {
+		if !tileData[0].IsValid(i) {
+			continue
+		}
+
 		// extract the 16 bit pixel values for each input band
 		value := int(val)
 		index := c.IndexMap[value]
@@ -0,0 +1,33 @@
+package analytics
+
+import (
+	"path"
+	"testing"
+)
+
+// Returns a tile whose band images have already been loaded, so that analytics can be set up without
+// reading band files.
+func loadedTile(inputDir string, date string, timestamp int64, bands map[string]*GeoImage) *Tile {
+	tile := &Tile{GeoHash: "9q8yy", Date: date, Timestamp: timestamp, images: map[string]*GeoImage{}}
+	for band, image := range bands {
+		filePath := path.Join(inputDir, tile.GeoHash+"_"+date+"_"+band+".tif")
+		tile.images[imageKey(filePath, 1)] = image
+	}
+	return tile
+}
+
+func TestCategoryCountsSetup(t *testing.T) {
+	loaded := &GeoImage{Data: []float64{10, 20}, XSize: 2, YSize: 1}
+	tile := loadedTile("/tiles", "20200101T103000", 1577874600, map[string]*GeoImage{discreteLandCoverBand: loaded})
+
+	images, err := CategoryCounts{}.Setup("/tiles", tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !images[0].Categorical {
+		t.Error("land cover image isn't categorical")
+	}
+	if images[0] == loaded || loaded.Categorical {
+		t.Error("the shared image was modified")
+	}
+}
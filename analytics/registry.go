@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"sort"
+	"sync"
+)
+
+// Factory describes an operation, and creates analytics for it from the dataset metadata and
+// runtime options.
+type Factory struct {
+	// Description is a short, human readable description of the operation.
+	Description string
+	// Bands lists the bands the operation loads for each tile.  Operations that derive their
+	// bands from the metadata or options leave this empty.
+	Bands []string
+	// New creates an analytic for the operation.  Per-tile operations return a Transformer, and
+	// operations on the series of tiles for a geohash return a TemporalTransformer.
+	New func(metadata JSONString, options Options) (Analytic, error)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[Operation]Factory{}
+)
+
+// Register makes an operation available by name to CreateTileAnalytic.  Register is intended to be
+// called from the init function of packages providing operations, and panics if an operation is
+// registered twice or the factory is missing its constructor.
+func Register(name Operation, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if factory.New == nil {
+		panic("analytics: Register factory for " + string(name) + " is missing its constructor")
+	}
+	if _, ok := registry[name]; ok {
+		panic("analytics: Register called twice for operation " + string(name))
+	}
+	registry[name] = factory
+}
+
+// Lookup returns the factory registered for an operation.
+func Lookup(name Operation) (Factory, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Operations returns the names of the registered operations in sorted order.
+func Operations() []Operation {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]Operation, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// Registers the built in operations.
+func init() {
+	Register(OperationCategoryCountsRaw, Factory{
+		Description: "Pixel count for each land cover category",
+		Bands:       []string{discreteLandCoverBand},
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewCategoryCountsRaw(metadata)
+		},
+	})
+	Register(OperationCategoryCountsPercentage, Factory{
+		Description: "Fraction of valid pixels in each land cover category",
+		Bands:       []string{discreteLandCoverBand},
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewCategoryCountsPercentage(metadata)
+		},
+	})
+	Register(OperationCategoryBinary, Factory{
+		Description: "Presence (1) or absence (0) of each land cover category",
+		Bands:       []string{discreteLandCoverBand},
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewCategoryBinary(metadata)
+		},
+	})
+	Register(OperationMeanNDVI, Factory{
+		Description: "Mean normalized difference vegetation index",
+		Bands:       []string{band8, band4},
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return MeanNDVI{}, nil
+		},
+	})
+	Register(OperationMean, Factory{
+		Description: "Mean of the first band listed in the metadata",
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewMean(metadata)
+		},
+	})
+	Register(OperationStats, Factory{
+		Description: "Min, max, standard deviation, median, percentiles, skewness and pixel count of the first " +
+			"band listed in the metadata",
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewStats(metadata, options.Percentiles)
+		},
+	})
+	Register(OperationExpression, Factory{
+		Description: "Aggregated band math expression, using the bands referenced by the formula",
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewExpression(options.Expression, options.Aggregation)
+		},
+	})
+	for operation, index := range spectralIndices {
+		operation := operation
+		Register(operation, Factory{
+			Description: index.description,
+			Bands:       index.Bands,
+			New: func(metadata JSONString, options Options) (Analytic, error) {
+				return NewMeanSpectralIndex(operation)
+			},
+		})
+	}
+
+	// temporal operations
+	for _, operation := range []Operation{OperationDeltaPrevious, OperationDeltaFirst, OperationPercentChange} {
+		operation := operation
+		Register(operation, Factory{
+			Description: deltaDescriptions[operation],
+			New: func(metadata JSONString, options Options) (Analytic, error) {
+				source, err := createSource(metadata, options)
+				if err != nil {
+					return nil, err
+				}
+				return NewDelta(source, operation)
+			},
+		})
+	}
+	Register(OperationTrend, Factory{
+		Description: "Linear trend and annual seasonality of the source operation value for each geohash",
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			source, err := createSource(metadata, options)
+			if err != nil {
+				return nil, err
+			}
+			return NewTrend(source), nil
+		},
+	})
+	Register(OperationCategoryTransitions, Factory{
+		Description: "Pixel count for each pair of land cover categories between consecutive dates",
+		Bands:       []string{discreteLandCoverBand},
+		New: func(metadata JSONString, options Options) (Analytic, error) {
+			return NewCategoryTransitions(metadata)
+		},
+	})
+}
@@ -0,0 +1,232 @@
+package analytics
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Resampling defines the method used to resample the bands of a tile to a common grid.
+type Resampling string
+
+const (
+	// ResamplingNone disables resampling, so bands of different sizes can't be combined.
+	ResamplingNone = "none"
+
+	// ResamplingNearest uses the value of the source pixel nearest to each pixel.
+	ResamplingNearest = "nearest"
+
+	// ResamplingBilinear interpolates between the four source pixels nearest to each pixel.
+	ResamplingBilinear = "bilinear"
+
+	// ResamplingAverage uses the mean of the valid source pixels covered by each pixel.
+	ResamplingAverage = "average"
+
+	// ResamplingMode uses the most common of the valid source pixels covered by each pixel.
+	ResamplingMode = "mode"
+)
+
+// Resamples the images loaded for an analytic to a common grid when their sizes differ.  The grid is that
+// of the finest image, or has the given resolution in the units of the images' coordinate system.
+func resampleImages(images []*GeoImage, resampling Resampling, resolution float64) ([]*GeoImage, error) {
+	if len(images) == 0 {
+		return images, nil
+	}
+	xSize, ySize, err := targetGrid(images, resolution)
+	if err != nil {
+		return nil, err
+	}
+	if allSized(images, xSize, ySize) {
+		return images, nil
+	}
+	if resampling == ResamplingNone || resampling == "" {
+		return nil, errors.Errorf("band sizes differ (%dx%d, %dx%d) and resampling is disabled",
+			images[0].XSize, images[0].YSize, xSize, ySize)
+	}
+
+	resampled := make([]*GeoImage, len(images))
+	for i, image := range images {
+		resampled[i], err = resampleToGrid(image, xSize, ySize, resampling)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resampled, nil
+}
+
+// Resamples an image to the grid unless it already has the grid's size.  Categorical images are resampled
+// using the mode unless nearest neighbour resampling is requested.
+func resampleToGrid(image *GeoImage, xSize int, ySize int, resampling Resampling) (*GeoImage, error) {
+	if image.XSize == xSize && image.YSize == ySize {
+		return image, nil
+	}
+	if image.Categorical && resampling != ResamplingNearest {
+		resampling = ResamplingMode
+	}
+	return resampleImage(image, xSize, ySize, resampling)
+}
+
+// Returns the size of the grid the images are resampled to - the grid of the finest image, or the grid
+// covering the first image at the given resolution.
+func targetGrid(images []*GeoImage, resolution float64) (int, int, error) {
+	xSize, ySize := images[0].XSize, images[0].YSize
+	if resolution > 0 {
+		if images[0].PixelWidth == 0 || images[0].PixelHeight == 0 {
+			return 0, 0, errors.New("pixel size unknown - can't resample to a resolution")
+		}
+		xSize = int(math.Round(float64(images[0].XSize) * images[0].PixelWidth / resolution))
+		ySize = int(math.Round(float64(images[0].YSize) * images[0].PixelHeight / resolution))
+		return xSize, ySize, nil
+	}
+	for _, image := range images[1:] {
+		if image.XSize*image.YSize > xSize*ySize {
+			xSize, ySize = image.XSize, image.YSize
+		}
+	}
+	return xSize, ySize, nil
+}
+
+// Returns true if all of the images have the given size.
+func allSized(images []*GeoImage, xSize int, ySize int) bool {
+	for _, image := range images {
+		if image.XSize != xSize || image.YSize != ySize {
+			return false
+		}
+	}
+	return true
+}
+
+// Resamples an image to a grid of the given size covering the same bounds.  Resampled pixels are invalid if
+// none of the source pixels they are computed from are valid.
+func resampleImage(image *GeoImage, xSize int, ySize int, resampling Resampling) (*GeoImage, error) {
+	var sample func(x int, y int) (float64, bool)
+	switch resampling {
+	case ResamplingNearest:
+		sample = func(x int, y int) (float64, bool) {
+			index := nearestIndex(y, ySize, image.YSize)*image.XSize + nearestIndex(x, xSize, image.XSize)
+			return image.Data[index], image.IsValid(index)
+		}
+	case ResamplingBilinear:
+		sample = func(x int, y int) (float64, bool) {
+			return bilinearSample(image, x, y, xSize, ySize)
+		}
+	case ResamplingAverage, ResamplingMode:
+		sample = func(x int, y int) (float64, bool) {
+			x0, x1 := coveredRange(x, xSize, image.XSize)
+			y0, y1 := coveredRange(y, ySize, image.YSize)
+			return windowSample(image, x0, x1, y0, y1, resampling == ResamplingMode)
+		}
+	default:
+		return nil, errors.Errorf("unrecognized resampling method %s", resampling)
+	}
+
+	resampled := &GeoImage{
+		Data:        make([]float64, xSize*ySize),
+		Valid:       make([]bool, xSize*ySize),
+		XSize:       xSize,
+		YSize:       ySize,
+		Bounds:      image.Bounds,
+		CRS:         image.CRS,
+		Scaled:      image.Scaled,
+		Integer:     image.Integer,
+		Categorical: image.Categorical,
+		PixelWidth:  image.PixelWidth * float64(image.XSize) / float64(xSize),
+		PixelHeight: image.PixelHeight * float64(image.YSize) / float64(ySize),
+	}
+	for y := 0; y < ySize; y++ {
+		for x := 0; x < xSize; x++ {
+			index := y*xSize + x
+			resampled.Data[index], resampled.Valid[index] = sample(x, y)
+		}
+	}
+	return resampled, nil
+}
+
+// Returns the index of the source pixel containing the centre of a target pixel.
+func nearestIndex(target int, targetSize int, sourceSize int) int {
+	index := int((float64(target) + 0.5) * float64(sourceSize) / float64(targetSize))
+	if index >= sourceSize {
+		index = sourceSize - 1
+	}
+	return index
+}
+
+// Returns the range of source pixels covered by a target pixel.  At least the source pixel containing the
+// target pixel's centre is included.
+func coveredRange(target int, targetSize int, sourceSize int) (int, int) {
+	scale := float64(sourceSize) / float64(targetSize)
+	start := int(math.Floor(float64(target) * scale))
+	end := int(math.Ceil(float64(target+1) * scale))
+	if end > sourceSize {
+		end = sourceSize
+	}
+	if end <= start {
+		start = nearestIndex(target, targetSize, sourceSize)
+		end = start + 1
+	}
+	return start, end
+}
+
+// Interpolates between the four source pixels surrounding a target pixel's centre.  If any of them are
+// invalid the nearest source pixel is used instead.
+func bilinearSample(image *GeoImage, x int, y int, xSize int, ySize int) (float64, bool) {
+	sourceX := clamp((float64(x)+0.5)*float64(image.XSize)/float64(xSize)-0.5, float64(image.XSize-1))
+	sourceY := clamp((float64(y)+0.5)*float64(image.YSize)/float64(ySize)-0.5, float64(image.YSize-1))
+	x0, y0 := int(sourceX), int(sourceY)
+	x1, y1 := x0+1, y0+1
+	if x1 >= image.XSize {
+		x1 = x0
+	}
+	if y1 >= image.YSize {
+		y1 = y0
+	}
+
+	indexes := [4]int{y0*image.XSize + x0, y0*image.XSize + x1, y1*image.XSize + x0, y1*image.XSize + x1}
+	for _, index := range indexes {
+		if !image.IsValid(index) {
+			nearest := nearestIndex(y, ySize, image.YSize)*image.XSize + nearestIndex(x, xSize, image.XSize)
+			return image.Data[nearest], image.IsValid(nearest)
+		}
+	}
+
+	fx, fy := sourceX-float64(x0), sourceY-float64(y0)
+	top := image.Data[indexes[0]]*(1-fx) + image.Data[indexes[1]]*fx
+	bottom := image.Data[indexes[2]]*(1-fx) + image.Data[indexes[3]]*fx
+	return top*(1-fy) + bottom*fy, true
+}
+
+// Computes the mean or the mode of the valid source pixels in a window.
+func windowSample(image *GeoImage, x0 int, x1 int, y0 int, y1 int, mode bool) (float64, bool) {
+	total := 0.0
+	count := 0
+	counts := map[float64]int{}
+	best := 0.0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			index := y*image.XSize + x
+			if !image.IsValid(index) {
+				continue
+			}
+			value := image.Data[index]
+			total += value
+			count++
+			if mode {
+				counts[value]++
+				if counts[value] > counts[best] || (counts[value] == counts[best] && value < best) {
+					best = value
+				}
+			}
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	if mode {
+		return best, true
+	}
+	return total / float64(count), true
+}
+
+func clamp(value float64, max float64) float64 {
+	return math.Max(0, math.Min(value, max))
+}
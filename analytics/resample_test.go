@@ -0,0 +1,151 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResampleImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      *GeoImage
+		xSize      int
+		ySize      int
+		resampling Resampling
+		want       []float64
+		valid      []bool
+	}{
+		{
+			name:       "nearest upsample",
+			image:      &GeoImage{Data: []float64{1, 2, 3, 4}, XSize: 2, YSize: 2},
+			xSize:      4,
+			ySize:      4,
+			resampling: ResamplingNearest,
+			want:       []float64{1, 1, 2, 2, 1, 1, 2, 2, 3, 3, 4, 4, 3, 3, 4, 4},
+		},
+		{
+			name:       "nearest downsample",
+			image:      &GeoImage{Data: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9}, XSize: 3, YSize: 3},
+			xSize:      1,
+			ySize:      1,
+			resampling: ResamplingNearest,
+			want:       []float64{5},
+		},
+		{
+			name:       "bilinear upsample",
+			image:      &GeoImage{Data: []float64{0, 1, 2, 3}, XSize: 2, YSize: 2},
+			xSize:      4,
+			ySize:      2,
+			resampling: ResamplingBilinear,
+			want:       []float64{0, 0.25, 0.75, 1, 2, 2.25, 2.75, 3},
+		},
+		{
+			name: "bilinear falls back to nearest next to invalid pixels",
+			image: &GeoImage{Data: []float64{0, 1, 2, 3}, XSize: 2, YSize: 1,
+				Valid: []bool{true, true, false, true}},
+			xSize:      4,
+			ySize:      1,
+			resampling: ResamplingBilinear,
+			want:       []float64{0, 0.25, 0.75, 1},
+		},
+		{
+			name: "average downsample skips invalid pixels",
+			image: &GeoImage{
+				Data:  []float64{1, 2, 3, 4, 5, 6, 7, 8},
+				Valid: []bool{true, true, false, false, true, true, false, false},
+				XSize: 4,
+				YSize: 2,
+			},
+			xSize:      2,
+			ySize:      1,
+			resampling: ResamplingAverage,
+			want:       []float64{3.5, 0},
+			valid:      []bool{true, false},
+		},
+		{
+			name:       "mode downsample",
+			image:      &GeoImage{Data: []float64{2, 2, 3, 3, 1, 2, 3, 4}, XSize: 4, YSize: 2},
+			xSize:      2,
+			ySize:      1,
+			resampling: ResamplingMode,
+			want:       []float64{2, 3},
+		},
+		{
+			name:       "mode ties use the lowest value",
+			image:      &GeoImage{Data: []float64{5, 3, 3, 5}, XSize: 2, YSize: 2},
+			xSize:      1,
+			ySize:      1,
+			resampling: ResamplingMode,
+			want:       []float64{3},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := resampleImage(test.image, test.xSize, test.ySize, test.resampling)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if got.XSize != test.xSize || got.YSize != test.ySize || len(got.Data) != len(test.want) {
+			t.Errorf("%s: resampled to %dx%d with %d pixels", test.name, got.XSize, got.YSize, len(got.Data))
+			continue
+		}
+		for i, want := range test.want {
+			valid := test.valid == nil || test.valid[i]
+			if got.Valid[i] != valid || (valid && math.Abs(got.Data[i]-want) > 1e-9) {
+				t.Errorf("%s: pixel %d = %v (valid %v), want %v (valid %v)",
+					test.name, i, got.Data[i], got.Valid[i], want, valid)
+			}
+		}
+	}
+}
+
+func TestResampleImages(t *testing.T) {
+	bounds := GeoBounds{MinLon: 1, MinLat: 2, MaxLon: 3, MaxLat: 4}
+	fine := &GeoImage{Data: make([]float64, 16), XSize: 4, YSize: 4, PixelWidth: 10, PixelHeight: 10,
+		Bounds: bounds, CRS: "EPSG:32633"}
+	coarse := &GeoImage{Data: []float64{1, 2, 3, 4}, XSize: 2, YSize: 2, PixelWidth: 20, PixelHeight: 20,
+		Bounds: bounds, CRS: "EPSG:32633", Categorical: true}
+
+	// the coarse image is resampled to the grid of the fine image
+	images, err := resampleImages([]*GeoImage{coarse, fine}, ResamplingBilinear, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images[1] != fine {
+		t.Error("image on the target grid was resampled")
+	}
+	resampled := images[0]
+	if resampled.XSize != 4 || resampled.YSize != 4 || resampled.PixelWidth != 10 || resampled.PixelHeight != 10 {
+		t.Errorf("resampled to %dx%d with %vx%v pixels", resampled.XSize, resampled.YSize,
+			resampled.PixelWidth, resampled.PixelHeight)
+	}
+	if resampled.Bounds != bounds || resampled.CRS != coarse.CRS || !resampled.Categorical {
+		t.Errorf("resampled image has bounds %v, crs %s and categorical %v", resampled.Bounds, resampled.CRS,
+			resampled.Categorical)
+	}
+	// categorical images are resampled using the mode rather than interpolated
+	for i, value := range resampled.Data {
+		if value != math.Trunc(value) {
+			t.Errorf("categorical pixel %d interpolated to %v", i, value)
+		}
+	}
+
+	// both images are resampled to a given resolution
+	images, err = resampleImages([]*GeoImage{fine, coarse}, ResamplingAverage, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, image := range images {
+		if image.XSize != 1 || image.YSize != 1 {
+			t.Errorf("image %d resampled to %dx%d, want 1x1", i, image.XSize, image.YSize)
+		}
+	}
+
+	if _, err := resampleImages([]*GeoImage{fine, coarse}, ResamplingNone, 0); err == nil {
+		t.Error("expected an error when resampling is disabled")
+	}
+	if images, err := resampleImages([]*GeoImage{fine, fine}, ResamplingNone, 0); err != nil || images[0] != fine {
+		t.Errorf("images of the same size were resampled (error %v)", err)
+	}
+}
@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+// OperationStats computes descriptive statistics for a tile.
+const OperationStats = "stats"
+
+// DefaultPercentiles are the percentiles computed by the stats operation when none are
+// supplied.
+var DefaultPercentiles = []float64{5, 25, 75, 95}
+
+// Stats computes descriptive statistics for a single band tile.
+type Stats struct {
+	ColumnName  string
+	Percentiles []float64
+}
+
+// NewStats creates a new stats operation.
+func NewStats(metadata JSONString, percentiles []float64) (*Stats, error) {
+	// fetch band name
+	result := gjson.Get(string(metadata), "bands.0.id")
+	if result.String() == "" {
+		return nil, errors.Errorf("failed to find band ID in metadata")
+	}
+
+	if len(percentiles) == 0 {
+		percentiles = DefaultPercentiles
+	}
+	for _, p := range percentiles {
+		if p < 0 || p > 100 {
+			return nil, errors.Errorf("percentile %v is not in the range [0, 100]", p)
+		}
+	}
+
+	return &Stats{ColumnName: result.String(), Percentiles: percentiles}, nil
+}
+
+// Setup loads the data for the stats tile transformation.
+func (s Stats) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
+	image, err := loadBand(inputDir, tile, s.ColumnName)
+	if err != nil {
+		return nil, err
+	}
+	return []*GeoImage{image}, nil
+}
+
+// Transform implements the stats tile transformation, which computes the min, max, standard
+// deviation, median, percentiles, skewness and valid pixel count for a given tile.  Invalid pixels
+// are excluded.
+func (s Stats) Transform(tileData []*GeoImage) ([]float64, error) {
+	values := make([]float64, 0, len(tileData[0].Data))
+	for i, value := range tileData[0].Data {
+		if !tileData[0].IsValid(i) || math.IsNaN(value) {
+			continue
+		}
+		values = append(values, value)
+	}
+	sort.Float64s(values)
+
+	results := make([]float64, 0, len(s.ValueNames()))
+	if len(values) == 0 {
+		// nothing to describe - report a count and valid fraction of 0
+		for range s.ValueNames() {
+			results = append(results, math.NaN())
+		}
+		results[len(results)-2] = 0
+		results[len(results)-1] = 0
+		return results, nil
+	}
+
+	// compute the central moments used by the standard deviation and skewness
+	avg := mean(values)
+	m2 := 0.0
+	m3 := 0.0
+	for _, value := range values {
+		d := value - avg
+		m2 += d * d
+		m3 += d * d * d
+	}
+	m2 /= float64(len(values))
+	m3 /= float64(len(values))
+
+	skewness := 0.0
+	if m2 > 0 {
+		skewness = m3 / math.Pow(m2, 1.5)
+	}
+
+	results = append(results, values[0], values[len(values)-1], math.Sqrt(m2), median(values))
+	for _, p := range s.Percentiles {
+		results = append(results, percentile(values, p))
+	}
+	results = append(results, skewness, float64(len(values)), validFraction(tileData))
+	return results, nil
+}
+
+// ValueNames returns the names of the statistics in the same order as they are returned by the
+// Transform call.
+func (s Stats) ValueNames() []string {
+	names := []string{
+		fmt.Sprintf("%s_min", s.ColumnName),
+		fmt.Sprintf("%s_max", s.ColumnName),
+		fmt.Sprintf("%s_std", s.ColumnName),
+		fmt.Sprintf("%s_median", s.ColumnName),
+	}
+	for _, p := range s.Percentiles {
+		names = append(names, fmt.Sprintf("%s_p%s", s.ColumnName, strconv.FormatFloat(p, 'f', -1, 64)))
+	}
+	return append(names,
+		fmt.Sprintf("%s_skewness", s.ColumnName),
+		fmt.Sprintf("%s_count", s.ColumnName),
+		validFractionName)
+}
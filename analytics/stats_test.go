@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{sorted, 0, 1},
+		{sorted, 100, 5},
+		{sorted, 50, 3},
+		{sorted, 25, 2},
+		{sorted, 10, 1.4},
+		{sorted, 95, 4.8},
+		{[]float64{7}, 50, 7},
+		{[]float64{1, 3}, 50, 2},
+	}
+	for _, test := range tests {
+		if got := percentile(test.values, test.p); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("percentile(%v, %v) = %v, want %v", test.values, test.p, got, test.want)
+		}
+	}
+	if got := percentile(nil, 50); !math.IsNaN(got) {
+		t.Errorf("percentile of no values = %v, want NaN", got)
+	}
+}
+
+func TestStatsTransform(t *testing.T) {
+	s, err := NewStats(`{"bands": [{"id": "B04"}]}`, []float64{25, 75})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := &GeoImage{
+		Data:  []float64{4, 1, 3, 2, 100, math.NaN()},
+		Valid: []bool{true, true, true, true, false, true},
+	}
+
+	values, err := s.Transform([]*GeoImage{image})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := s.ValueNames()
+	if len(values) != len(names) {
+		t.Fatalf("%d values for %d names", len(values), len(names))
+	}
+	want := map[string]float64{
+		"B04_min":         1,
+		"B04_max":         4,
+		"B04_std":         math.Sqrt(1.25),
+		"B04_median":      2.5,
+		"B04_p25":         1.75,
+		"B04_p75":         3.25,
+		"B04_skewness":    0,
+		"B04_count":       4,
+		validFractionName: 5.0 / 6,
+	}
+	for i, name := range names {
+		if math.Abs(values[i]-want[name]) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, values[i], want[name])
+		}
+	}
+}
+
+func TestStatsTransformEmpty(t *testing.T) {
+	s, err := NewStats(`{"bands": [{"id": "B04"}]}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.Transform([]*GeoImage{{Data: []float64{1, 2}, Valid: []bool{false, false}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range s.ValueNames() {
+		if name == "B04_count" || name == validFractionName {
+			if values[i] != 0 {
+				t.Errorf("%s = %v, want 0", name, values[i])
+			}
+		} else if !math.IsNaN(values[i]) {
+			t.Errorf("%s = %v, want NaN", name, values[i])
+		}
+	}
+}
+
+func TestNewStatsErrors(t *testing.T) {
+	if _, err := NewStats(`{}`, nil); err == nil {
+		t.Error("expected an error for metadata without bands")
+	}
+	if _, err := NewStats(`{"bands": [{"id": "B04"}]}`, []float64{101}); err == nil {
+		t.Error("expected an error for a percentile above 100")
+	}
+}
@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// OperationDeltaPrevious computes the change in a source analytic value from the previous date.
+	OperationDeltaPrevious = "delta_previous"
+
+	// OperationDeltaFirst computes the change in a source analytic value from the first date.
+	OperationDeltaFirst = "delta_first"
+
+	// OperationPercentChange computes the percentage change in a source analytic value from the
+	// previous date.
+	OperationPercentChange = "percent_change"
+)
+
+// TemporalTransformer is an interface that defines an operation on the date ordered series of
+// tiles for a geohash.
+type TemporalTransformer interface {
+	Analytic
+	TransformSeries(inputDir string, tiles []Tile) []TileValues
+}
+
+// Descriptions of the delta operations.
+var deltaDescriptions = map[Operation]string{
+	OperationDeltaPrevious: "Change in the source operation value from the previous date",
+	OperationDeltaFirst:    "Change in the source operation value from the first date",
+	OperationPercentChange: "Percentage change in the source operation value from the previous date",
+}
+
+// Creates the per-tile source analytic that a temporal analytic is applied to.
+func createSource(metadata JSONString, options Options) (Transformer, error) {
+	if _, ok := Lookup(options.Source); !ok {
+		return nil, errors.Errorf("unrecognized source operation %s", options.Source)
+	}
+
+	// clear the source so that a temporal source operation can't recurse
+	sourceOptions := options
+	sourceOptions.Source = ""
+	analytic, err := CreateTileAnalytic(metadata, options.Source, sourceOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create source operation %s", options.Source)
+	}
+	source, ok := analytic.(Transformer)
+	if !ok {
+		return nil, errors.Errorf("source operation %s must be a per-tile operation", options.Source)
+	}
+	return source, nil
+}
+
+// Delta computes the change in the first value generated by a source analytic between the dates
+// of a geohash series.
+type Delta struct {
+	Source Transformer
+	Mode   Operation
+}
+
+// NewDelta creates a new delta operation for one of the delta operation types.
+func NewDelta(source Transformer, mode Operation) (*Delta, error) {
+	if mode != OperationDeltaPrevious && mode != OperationDeltaFirst && mode != OperationPercentChange {
+		return nil, errors.Errorf("unrecognized delta operation %s", mode)
+	}
+	return &Delta{Source: source, Mode: mode}, nil
+}
+
+// TransformSeries applies the source analytic to each tile in the series, and computes the change
+// in its value relative to the first or previous date.  The change for the first date in the series
+// is NaN, and dates where the source value is NaN are not used as a reference.  Percentage changes are
+// relative to the magnitude of the previous value, and are NaN when it is zero.
+func (d Delta) TransformSeries(inputDir string, tiles []Tile) []TileValues {
+	results := make([]TileValues, len(tiles))
+	first := math.NaN()
+	previous := math.NaN()
+	for i, tile := range tiles {
+		results[i] = Apply(inputDir, tile, d.Source)
+		if results[i].Err != nil {
+			continue
+		}
+		value := results[i].Values[0]
+
+		change := math.NaN()
+		switch d.Mode {
+		case OperationDeltaPrevious:
+			change = value - previous
+		case OperationDeltaFirst:
+			change = value - first
+		case OperationPercentChange:
+			if previous != 0 {
+				change = (value - previous) / math.Abs(previous) * 100
+			}
+		}
+		results[i].Values = []float64{value, change}
+
+		if !math.IsNaN(value) {
+			if math.IsNaN(first) {
+				first = value
+			}
+			previous = value
+		}
+	}
+	return results
+}
+
+// ValueNames returns the name of the source value and the change in that value.
+func (d Delta) ValueNames() []string {
+	name := d.Source.ValueNames()[0]
+	return []string{name, fmt.Sprintf("%s_%s", name, d.Mode)}
+}
@@ -0,0 +1,92 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// seriesSource is a per-tile analytic generating a fixed value for each tile timestamp, failing for
+// timestamps without a value.
+type seriesSource struct {
+	values map[int64]float64
+}
+
+func (s seriesSource) Setup(inputDir string, tile *Tile) ([]*GeoImage, error) {
+	value, ok := s.values[tile.Timestamp]
+	if !ok {
+		return nil, errors.New("no value")
+	}
+	return []*GeoImage{{Data: []float64{value}, XSize: 1, YSize: 1}}, nil
+}
+
+func (s seriesSource) Transform(tileData []*GeoImage) ([]float64, error) {
+	return []float64{tileData[0].Data[0]}, nil
+}
+
+func (s seriesSource) ValueNames() []string {
+	return []string{"value"}
+}
+
+func TestDeltaTransformSeries(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		mode   Operation
+		values []float64
+		want   []float64
+	}{
+		{"previous", OperationDeltaPrevious, []float64{1, 3, 2}, []float64{nan, 2, -1}},
+		{"previous skips NaN", OperationDeltaPrevious, []float64{1, nan, 4, 2}, []float64{nan, nan, 3, -2}},
+		{"first", OperationDeltaFirst, []float64{1, 3, 2}, []float64{nan, 2, 1}},
+		{"first skips NaN", OperationDeltaFirst, []float64{nan, 2, 5}, []float64{nan, nan, 3}},
+		{"percent", OperationPercentChange, []float64{2, 3, 1.5}, []float64{nan, 50, -50}},
+		{"percent from negative", OperationPercentChange, []float64{-2, 1, -4}, []float64{nan, 150, -500}},
+		{"percent from zero", OperationPercentChange, []float64{0, 1, 0, 0}, []float64{nan, nan, -100, nan}},
+	}
+
+	for _, test := range tests {
+		// failed tiles are interleaved with the series, and don't change the reference value
+		source := seriesSource{values: map[int64]float64{}}
+		tiles := []Tile{}
+		for i, value := range test.values {
+			source.values[int64(2*i)] = value
+			tiles = append(tiles, Tile{Timestamp: int64(2 * i)}, Tile{Timestamp: int64(2*i + 1)})
+		}
+		d, err := NewDelta(source, test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results := d.TransformSeries("", tiles)
+		if len(results) != len(tiles) {
+			t.Fatalf("%s: %d results for %d tiles", test.name, len(results), len(tiles))
+		}
+		for i, result := range results {
+			if result.Tile.Timestamp != tiles[i].Timestamp {
+				t.Errorf("%s: result %d is for tile %d", test.name, i, result.Tile.Timestamp)
+			}
+			if i%2 == 1 {
+				if result.Err == nil {
+					t.Errorf("%s: result %d didn't fail", test.name, i)
+				}
+				continue
+			}
+			value, want := test.values[i/2], test.want[i/2]
+			if result.Err != nil || len(result.Values) != 2 || !sameFloat(result.Values[0], value) ||
+				!sameFloat(result.Values[1], want) {
+				t.Errorf("%s: result %d has values %v and error %v, want [%v %v]", test.name, i, result.Values,
+					result.Err, value, want)
+			}
+		}
+	}
+
+	if _, err := NewDelta(seriesSource{}, OperationTrend); err == nil {
+		t.Error("expected an error for an unrecognized delta operation")
+	}
+}
+
+// Returns true if the values are equal or both NaN.
+func sameFloat(a float64, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
@@ -0,0 +1,98 @@
+package analytics
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// OperationCategoryTransitions counts the pixels that change from each category to each other category
+// between consecutive dates of a geohash series.
+const OperationCategoryTransitions = "category_transitions"
+
+// CategoryTransitions computes a from / to matrix of category pixel counts between consecutive dates.
+type CategoryTransitions struct {
+	CategoryCounts
+}
+
+// NewCategoryTransitions creates a new CategoryTransitions operation.
+func NewCategoryTransitions(metadata JSONString) (*CategoryTransitions, error) {
+	c, err := NewCategoryCounts(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &CategoryTransitions{c}, nil
+}
+
+// TransformSeries loads the category data for each tile in the series, and counts the pixels of each
+// from / to category pair between the tile and the previous date.  No values are generated for the
+// first date.  Pixels that are invalid on either date, or that have a value not found in the category
+// metadata, are skipped.
+func (c CategoryTransitions) TransformSeries(inputDir string, tiles []Tile) []TileValues {
+	results := []TileValues{}
+	var previous *GeoImage
+	for _, tile := range tiles {
+		images, err := c.Setup(inputDir, &tile)
+		if err != nil {
+			results = append(results, TileValues{Tile: tile, Err: &TileError{Stage: StageSetup, Err: err}})
+			continue
+		}
+		current := images[0]
+		if previous != nil {
+			result := TileValues{Tile: tile, Bounds: current.Bounds, CRS: current.CRS}
+			pair, err := resampleImages([]*GeoImage{previous, current}, tile.Input.Resampling, tile.Input.Resolution)
+			if err != nil {
+				result.Err = &TileError{Stage: StageSetup, Err: err}
+				results = append(results, result)
+				continue
+			}
+			result.Values, err = c.countTransitions(pair[0], pair[1])
+			if err != nil {
+				result.Err = &TileError{Stage: StageTransform, Err: err}
+			}
+			results = append(results, result)
+		}
+		previous = current
+	}
+	return results
+}
+
+// ValueNames returns the names of the from / to category pairs, in row major order, followed by the
+// fraction of pixels valid on both dates.
+func (c CategoryTransitions) ValueNames() []string {
+	valueNames := make([]string, 0, len(c.Categories)*len(c.Categories)+1)
+	for _, from := range c.Categories {
+		for _, to := range c.Categories {
+			valueNames = append(valueNames, fmt.Sprintf("%s_to_%s", from.Label, to.Label))
+		}
+	}
+	return append(valueNames, validFractionName)
+}
+
+func (c CategoryTransitions) countTransitions(from *GeoImage, to *GeoImage) ([]float64, error) {
+	if len(c.Categories) == 0 {
+		return nil, errors.New("labels unspecified")
+	}
+	if from.XSize != to.XSize || from.YSize != to.YSize {
+		return nil, errors.Errorf("tile size %dx%d does not match previous tile size %dx%d",
+			to.XSize, to.YSize, from.XSize, from.YSize)
+	}
+
+	numCategories := len(c.Categories)
+	counts := make([]float64, numCategories*numCategories)
+	for i := range from.Data {
+		if !from.IsValid(i) || !to.IsValid(i) {
+			continue
+		}
+		fromIndex, ok := c.IndexMap[int(from.Data[i])]
+		if !ok {
+			continue
+		}
+		toIndex, ok := c.IndexMap[int(to.Data[i])]
+		if !ok {
+			continue
+		}
+		counts[fromIndex*numCategories+toIndex]++
+	}
+	return append(counts, validFraction([]*GeoImage{from, to})), nil
+}
@@ -0,0 +1,96 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Returns transitions between the water (10) and forest (20) categories.
+func testTransitions() CategoryTransitions {
+	return CategoryTransitions{CategoryCounts{
+		Categories: []CategoryData{{Value: 10, Label: "water"}, {Value: 20, Label: "forest"}},
+		IndexMap:   map[int]int{10: 0, 20: 1},
+	}}
+}
+
+func TestCountTransitions(t *testing.T) {
+	c := testTransitions()
+	tests := []struct {
+		name string
+		from *GeoImage
+		to   *GeoImage
+		want []float64
+	}{
+		{
+			name: "all valid",
+			from: &GeoImage{Data: []float64{10, 10, 20, 20}, XSize: 2, YSize: 2},
+			to:   &GeoImage{Data: []float64{10, 20, 20, 20}, XSize: 2, YSize: 2},
+			want: []float64{1, 1, 0, 2, 1},
+		},
+		{
+			name: "invalid on either date",
+			from: &GeoImage{Data: []float64{10, 10, 20, 20}, Valid: []bool{false, true, true, true}, XSize: 2, YSize: 2},
+			to:   &GeoImage{Data: []float64{10, 20, 20, 10}, Valid: []bool{true, true, false, true}, XSize: 2, YSize: 2},
+			want: []float64{0, 1, 1, 0, 0.5},
+		},
+		{
+			name: "unknown categories",
+			from: &GeoImage{Data: []float64{30, 10, 20}, XSize: 3, YSize: 1},
+			to:   &GeoImage{Data: []float64{10, 30, 10}, XSize: 3, YSize: 1},
+			want: []float64{0, 0, 1, 0, 1},
+		},
+	}
+	for _, test := range tests {
+		got, err := c.countTransitions(test.from, test.to)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: counted %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	_, err := c.countTransitions(&GeoImage{Data: make([]float64, 4), XSize: 2, YSize: 2},
+		&GeoImage{Data: make([]float64, 4), XSize: 4, YSize: 1})
+	if err == nil {
+		t.Error("expected an error for images of different sizes")
+	}
+	if _, err := (CategoryTransitions{}).countTransitions(&GeoImage{}, &GeoImage{}); err == nil {
+		t.Error("expected an error without categories")
+	}
+}
+
+func TestCategoryTransitionsTransformSeries(t *testing.T) {
+	c := testTransitions()
+	band := discreteLandCoverBand
+	tiles := []Tile{
+		*loadedTile("/tiles", "20200101T103000", 1577874600,
+			map[string]*GeoImage{band: {Data: []float64{10, 20}, XSize: 2, YSize: 1}}),
+		// missing land cover band
+		*loadedTile("/tiles", "20200102T103000", 1577961000, nil),
+		*loadedTile("/tiles", "20200103T103000", 1578047400,
+			map[string]*GeoImage{band: {Data: []float64{20, 20}, XSize: 2, YSize: 1}}),
+		*loadedTile("/tiles", "20200104T103000", 1578133800,
+			map[string]*GeoImage{band: {Data: []float64{10, 10, 20, 20}, XSize: 4, YSize: 1}}),
+	}
+	tiles[3].Input.Resampling = ResamplingNone
+
+	results := c.TransformSeries("/tiles", tiles)
+	if len(results) != 3 {
+		t.Fatalf("%d results, want 3", len(results))
+	}
+	if results[0].Tile.Date != tiles[1].Date || results[0].Err == nil {
+		t.Errorf("missing band of %s wasn't reported", tiles[1].Date)
+	}
+	// the transitions are counted from the last tile that loaded
+	want := []float64{0, 1, 0, 1, 1}
+	if results[1].Tile.Date != tiles[2].Date || fmt.Sprint(results[1].Values) != fmt.Sprint(want) {
+		t.Errorf("%s has values %v and error %v, want %v", results[1].Tile.Date, results[1].Values,
+			results[1].Err, want)
+	}
+	// the tile sizes differ and resampling is disabled
+	if results[2].Tile.Date != tiles[3].Date || results[2].Err == nil {
+		t.Errorf("size mismatch of %s wasn't reported", tiles[3].Date)
+	}
+}
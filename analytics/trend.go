@@ -0,0 +1,191 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// OperationTrend computes linear trend and seasonality features for the series of source
+	// analytic values of each geohash.
+	OperationTrend = "trend"
+
+	secondsPerDay = 24 * 60 * 60
+	daysPerYear   = 365.25
+)
+
+// Trend fits a linear trend with an annual harmonic to the first value generated by a source analytic
+// over the dates of a geohash series.  A single set of values is generated for each geohash, associated
+// with the last date in the series.
+type Trend struct {
+	Source Transformer
+}
+
+// NewTrend creates a new trend operation.
+func NewTrend(source Transformer) *Trend {
+	return &Trend{Source: source}
+}
+
+// TransformSeries applies the source analytic to each tile in the series, and fits
+// value = intercept + slope * t + a * cos(ωt) + b * sin(ωt) using least squares, where t is in years
+// since the first date and ω is an annual frequency.  The slope (per year), intercept (trend value at the
+// first date), R² of the fit, seasonal amplitude and phase (day of year of the peak) and the number of
+// observations are returned.  The seasonal terms are omitted from the fit when there are fewer than 4
+// observations, and dates where the source value is NaN are excluded.
+func (t Trend) TransformSeries(inputDir string, tiles []Tile) []TileValues {
+	results := []TileValues{}
+	days := []float64{}
+	values := []float64{}
+	var last *TileValues
+	for _, tile := range tiles {
+		result := Apply(inputDir, tile, t.Source)
+		if result.Err != nil {
+			results = append(results, result)
+			continue
+		}
+		if math.IsNaN(result.Values[0]) {
+			continue
+		}
+		days = append(days, float64(tile.Timestamp)/secondsPerDay)
+		values = append(values, result.Values[0])
+		last = &result
+	}
+	if last == nil {
+		return results
+	}
+
+	last.Values = append(fitTrend(days, values), float64(len(values)))
+	return append(results, *last)
+}
+
+// ValueNames returns the names of the trend features in the same order as they are returned by
+// the TransformSeries call.
+func (t Trend) ValueNames() []string {
+	name := t.Source.ValueNames()[0]
+	return []string{
+		fmt.Sprintf("%s_slope", name),
+		fmt.Sprintf("%s_intercept", name),
+		fmt.Sprintf("%s_r2", name),
+		fmt.Sprintf("%s_seasonal_amplitude", name),
+		fmt.Sprintf("%s_seasonal_phase", name),
+		fmt.Sprintf("%s_observations", name),
+	}
+}
+
+// Fits the trend and annual harmonic model to values observed at the given days since the unix epoch,
+// returning the slope, intercept, R², seasonal amplitude and seasonal phase.  Values that can't be
+// estimated from the observations are NaN.
+func fitTrend(days []float64, values []float64) []float64 {
+	seasonal := len(values) >= 4
+	design := make([][]float64, len(days))
+	for i, day := range days {
+		years := (day - days[0]) / daysPerYear
+		design[i] = []float64{1, years}
+		if seasonal {
+			// measuring the angle from the epoch allows the phase to be read as a day of the year
+			angle := 2 * math.Pi * day / daysPerYear
+			design[i] = append(design[i], math.Cos(angle), math.Sin(angle))
+		}
+	}
+
+	coefficients := leastSquares(design, values)
+	if coefficients == nil {
+		return []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+	}
+
+	// compute the coefficient of determination of the fit
+	meanValue := mean(values)
+	ssRes := 0.0
+	ssTot := 0.0
+	for i, row := range design {
+		predicted := 0.0
+		for j, coefficient := range coefficients {
+			predicted += coefficient * row[j]
+		}
+		ssRes += (values[i] - predicted) * (values[i] - predicted)
+		ssTot += (values[i] - meanValue) * (values[i] - meanValue)
+	}
+	r2 := 1.0
+	if ssTot > 0 {
+		r2 = 1 - ssRes/ssTot
+	}
+
+	amplitude := math.NaN()
+	phase := math.NaN()
+	if seasonal {
+		a := coefficients[2]
+		b := coefficients[3]
+		amplitude = math.Hypot(a, b)
+		phase = math.Mod(math.Atan2(b, a)/(2*math.Pi)*daysPerYear+daysPerYear, daysPerYear)
+	}
+	return []float64{coefficients[1], coefficients[0], r2, amplitude, phase}
+}
+
+// Solves the least squares problem design * x = y using the normal equations.  Returns nil if the
+// system is under-determined or singular.
+func leastSquares(design [][]float64, y []float64) []float64 {
+	if len(design) == 0 || len(design) < len(design[0]) {
+		return nil
+	}
+	system := normalEquations(design, y)
+	if !eliminate(system) {
+		return nil
+	}
+	return backSubstitute(system)
+}
+
+// Builds the augmented normal equations [AᵀA | Aᵀy].
+func normalEquations(design [][]float64, y []float64) [][]float64 {
+	n := len(design[0])
+	system := make([][]float64, n)
+	for i := range system {
+		system[i] = make([]float64, n+1)
+		for k, row := range design {
+			for j := 0; j < n; j++ {
+				system[i][j] += row[i] * row[j]
+			}
+			system[i][n] += row[i] * y[k]
+		}
+	}
+	return system
+}
+
+// Reduces an augmented system to upper triangular form by gaussian elimination with partial pivoting.
+// Returns false if the system is singular.
+func eliminate(system [][]float64) bool {
+	n := len(system)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(system[row][col]) > math.Abs(system[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(system[pivot][col]) < 1e-12 {
+			return false
+		}
+		system[col], system[pivot] = system[pivot], system[col]
+
+		for row := col + 1; row < n; row++ {
+			factor := system[row][col] / system[col][col]
+			for j := col; j <= n; j++ {
+				system[row][j] -= factor * system[col][j]
+			}
+		}
+	}
+	return true
+}
+
+// Solves an upper triangular augmented system by back substitution.
+func backSubstitute(system [][]float64) []float64 {
+	n := len(system)
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		total := system[row][n]
+		for j := row + 1; j < n; j++ {
+			total -= system[row][j] * x[j]
+		}
+		x[row] = total / system[row][row]
+	}
+	return x
+}
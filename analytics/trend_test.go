@@ -0,0 +1,94 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+)
+
+// 2020-01-01 in days since the unix epoch
+const trendTestStart = 18262.0
+
+func TestFitTrend(t *testing.T) {
+	tests := []struct {
+		name  string
+		days  []float64
+		value func(day float64) float64
+		want  []float64
+	}{
+		{
+			name:  "linear without seasonal terms",
+			days:  []float64{0, daysPerYear / 2, daysPerYear},
+			value: func(day float64) float64 { return 1 + 2*day/daysPerYear },
+			want:  []float64{2, 1, 1, math.NaN(), math.NaN()},
+		},
+		{
+			name:  "constant",
+			days:  []float64{0, 30, 60},
+			value: func(day float64) float64 { return 5 },
+			want:  []float64{0, 5, 1, math.NaN(), math.NaN()},
+		},
+		{
+			name: "trend with an annual cycle peaking on day 180",
+			days: monthlyDays(36),
+			value: func(day float64) float64 {
+				return 1 + 0.5*day/daysPerYear + 0.3*math.Cos(2*math.Pi*(trendTestStart+day-180)/daysPerYear)
+			},
+			want: []float64{0.5, 1, 1, 0.3, 180},
+		},
+	}
+
+	for _, test := range tests {
+		days := make([]float64, len(test.days))
+		values := make([]float64, len(test.days))
+		for i, day := range test.days {
+			days[i] = trendTestStart + day
+			values[i] = test.value(day)
+		}
+		got := fitTrend(days, values)
+		for i := range test.want {
+			if math.IsNaN(test.want[i]) {
+				if !math.IsNaN(got[i]) {
+					t.Errorf("%s: value %d = %v, want NaN", test.name, i, got[i])
+				}
+				continue
+			}
+			if math.Abs(got[i]-test.want[i]) > 1e-6 {
+				t.Errorf("%s: value %d = %v, want %v", test.name, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestFitTrendUnderdetermined(t *testing.T) {
+	for _, got := range fitTrend([]float64{trendTestStart}, []float64{1}) {
+		if !math.IsNaN(got) {
+			t.Errorf("fit of a single observation = %v, want NaN", got)
+		}
+	}
+}
+
+func TestLeastSquares(t *testing.T) {
+	// y = 1 + 2x
+	design := [][]float64{{1, 0}, {1, 1}, {1, 2}, {1, 3}}
+	got := leastSquares(design, []float64{1, 3, 5, 7})
+	if len(got) != 2 || math.Abs(got[0]-1) > 1e-9 || math.Abs(got[1]-2) > 1e-9 {
+		t.Errorf("coefficients %v, want [1 2]", got)
+	}
+
+	// identical columns make the system singular
+	if got := leastSquares([][]float64{{1, 1}, {2, 2}, {3, 3}}, []float64{1, 2, 3}); got != nil {
+		t.Errorf("singular system solved as %v", got)
+	}
+	if got := leastSquares([][]float64{{1, 2, 3}}, []float64{1}); got != nil {
+		t.Errorf("under-determined system solved as %v", got)
+	}
+}
+
+// Returns the day offsets of n observations a month apart.
+func monthlyDays(n int) []float64 {
+	days := make([]float64, n)
+	for i := range days {
+		days[i] = float64(i) * daysPerYear / 12
+	}
+	return days
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+const (
+	d3mSchemaVersion  = "4.0.0"
+	d3mDatasetVersion = "1.0"
+	d3mDocFile        = "datasetDoc.json"
+	d3mResourceID     = "learningData"
+	d3mDataPath       = "tables/learningData.csv"
+	d3mIndexName      = "d3mIndex"
+)
+
+// d3mDatasetDoc is the D3M dataset schema describing the learning data table.
+type d3mDatasetDoc struct {
+	About         d3mAbout          `json:"about"`
+	DataResources []d3mDataResource `json:"dataResources"`
+}
+
+type d3mAbout struct {
+	DatasetID            string `json:"datasetID"`
+	DatasetName          string `json:"datasetName"`
+	DatasetSchemaVersion string `json:"datasetSchemaVersion"`
+	DatasetVersion       string `json:"datasetVersion"`
+	Redacted             bool   `json:"redacted"`
+}
+
+type d3mDataResource struct {
+	ResID        string              `json:"resID"`
+	ResPath      string              `json:"resPath"`
+	ResType      string              `json:"resType"`
+	ResFormat    map[string][]string `json:"resFormat"`
+	IsCollection bool                `json:"isCollection"`
+	ColumnsCount int                 `json:"columnsCount"`
+	Columns      []d3mColumn         `json:"columns"`
+}
+
+type d3mColumn struct {
+	ColIndex int      `json:"colIndex"`
+	ColName  string   `json:"colName"`
+	ColType  string   `json:"colType"`
+	Role     []string `json:"role"`
+}
+
+// d3mResultWriter writes a D3M dataset directory containing the learning data table and the dataset
+// doc describing its columns, so that the output can be loaded into Distil directly.
+type d3mResultWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	index  int
+	crs    bool
+}
+
+func newD3MResultWriter(outputDir string, valueNames []string, crs bool) (*d3mResultWriter, error) {
+	dataPath := path.Join(outputDir, d3mDataPath)
+	err := os.MkdirAll(path.Dir(dataPath), os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dataset directory")
+	}
+
+	columns := createD3MColumns(valueNames, crs)
+	err = writeD3MDatasetDoc(outputDir, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(dataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create learning data file")
+	}
+	w := &d3mResultWriter{file: file, writer: csv.NewWriter(file), crs: crs}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.ColName
+	}
+	err = w.writer.Write(header)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "could not write learning data header")
+	}
+	return w, nil
+}
+
+// Returns the learning data columns - the d3m index, the geohash as a grouping key, the date as the time
+// indicator and the acquisition time, the bounds as the bounding polygon vector of the corner coordinates,
+// the optional crs and the real valued analytic values.
+func createD3MColumns(valueNames []string, crs bool) []d3mColumn {
+	columns := []d3mColumn{
+		{ColName: d3mIndexName, ColType: "integer", Role: []string{"index"}},
+		{ColName: "tile_id", ColType: "string", Role: []string{"attribute", "suggestedGroupingKey"}},
+		{ColName: "date", ColType: "dateTime", Role: []string{"attribute", "timeIndicator"}},
+		{ColName: "acquired", ColType: "dateTime", Role: []string{"attribute"}},
+		{ColName: "bounds", ColType: "realVector", Role: []string{"attribute", "boundingPolygon"}},
+	}
+	if crs {
+		columns = append(columns, d3mColumn{ColName: "crs", ColType: "string", Role: []string{"attribute"}})
+	}
+	for _, name := range valueNames {
+		columns = append(columns, d3mColumn{ColName: name, ColType: "real", Role: []string{"attribute"}})
+	}
+	for i := range columns {
+		columns[i].ColIndex = i
+	}
+	return columns
+}
+
+// Writes the dataset doc, naming the dataset after its directory.
+func writeD3MDatasetDoc(outputDir string, columns []d3mColumn) error {
+	name := path.Base(path.Clean(outputDir))
+	doc := d3mDatasetDoc{
+		About: d3mAbout{
+			DatasetID:            name + "_dataset",
+			DatasetName:          name,
+			DatasetSchemaVersion: d3mSchemaVersion,
+			DatasetVersion:       d3mDatasetVersion,
+		},
+		DataResources: []d3mDataResource{{
+			ResID:        d3mResourceID,
+			ResPath:      d3mDataPath,
+			ResType:      "table",
+			ResFormat:    map[string][]string{"text/csv": {"csv"}},
+			ColumnsCount: len(columns),
+			Columns:      columns,
+		}},
+	}
+
+	encoded, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode dataset doc")
+	}
+	err = ioutil.WriteFile(path.Join(outputDir, d3mDocFile), encoded, 0644)
+	return errors.Wrap(err, "failed to write dataset doc")
+}
+
+func (w *d3mResultWriter) write(result analytics.TileValues) error {
+	row := append([]string{strconv.Itoa(w.index)}, formatRow(result, w.crs)...)
+	w.index++
+	return w.writer.Write(row)
+}
+
+func (w *d3mResultWriter) close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write learning data file")
+	}
+	return w.file.Close()
+}
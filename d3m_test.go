@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+func TestD3MResultWriter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "d3m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	outputDir := path.Join(tempDir, "tiles")
+
+	w, err := newD3MResultWriter(outputDir, []string{"ndvi"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+	results := []analytics.TileValues{
+		{Tile: analytics.Tile{GeoHash: "9q8yy", Timestamp: 1577874600}, Bounds: bounds, CRS: "EPSG:32610",
+			Values: []float64{0.25}},
+		{Tile: analytics.Tile{GeoHash: "9q8yz", Timestamp: 1577874600}, Bounds: bounds, CRS: "EPSG:32610",
+			Values: []float64{0.5}},
+	}
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := ioutil.ReadFile(path.Join(outputDir, d3mDocFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc d3mDatasetDoc
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.About.DatasetID != "tiles_dataset" || doc.About.DatasetName != "tiles" {
+		t.Errorf("dataset id %s and name %s", doc.About.DatasetID, doc.About.DatasetName)
+	}
+	if len(doc.DataResources) != 1 {
+		t.Fatalf("%d data resources, want 1", len(doc.DataResources))
+	}
+	resource := doc.DataResources[0]
+	if resource.ResID != d3mResourceID || resource.ResPath != d3mDataPath {
+		t.Errorf("resource %s at %s", resource.ResID, resource.ResPath)
+	}
+
+	want := []struct {
+		name    string
+		colType string
+		roles   string
+	}{
+		{"d3mIndex", "integer", "index"},
+		{"tile_id", "string", "attribute,suggestedGroupingKey"},
+		{"date", "dateTime", "attribute,timeIndicator"},
+		{"acquired", "dateTime", "attribute"},
+		{"bounds", "realVector", "attribute,boundingPolygon"},
+		{"crs", "string", "attribute"},
+		{"ndvi", "real", "attribute"},
+	}
+	if resource.ColumnsCount != len(want) || len(resource.Columns) != len(want) {
+		t.Fatalf("columns count %d and %d columns, want %d", resource.ColumnsCount, len(resource.Columns), len(want))
+	}
+	for i, column := range resource.Columns {
+		roles := strings.Join(column.Role, ",")
+		if column.ColIndex != i || column.ColName != want[i].name || column.ColType != want[i].colType ||
+			roles != want[i].roles {
+			t.Errorf("column %d is %d %s %s [%s], want %s %s [%s]", i, column.ColIndex, column.ColName,
+				column.ColType, roles, want[i].name, want[i].colType, want[i].roles)
+		}
+	}
+
+	file, err := os.Open(path.Join(outputDir, d3mDataPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(results)+1 {
+		t.Fatalf("%d rows, want %d", len(rows), len(results)+1)
+	}
+	for i, name := range rows[0] {
+		if name != want[i].name {
+			t.Errorf("header column %d is %s, want %s", i, name, want[i].name)
+		}
+	}
+	wantRows := [][]string{
+		{"0", "9q8yy", "2020-01-01", "2020-01-01T10:30:00.000Z", bounds.String(), "EPSG:32610", "0.25"},
+		{"1", "9q8yz", "2020-01-01", "2020-01-01T10:30:00.000Z", bounds.String(), "EPSG:32610", "0.5"},
+	}
+	for i, row := range rows[1:] {
+		if strings.Join(row, "|") != strings.Join(wantRows[i], "|") {
+			t.Errorf("row %d is %v, want %v", i, row, wantRows[i])
+		}
+	}
+}
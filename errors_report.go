@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	errorsFormatCSV   = "csv"
+	errorsFormatJSONL = "jsonl"
+)
+
+// errorRecord describes a tile that could not be processed.
+type errorRecord struct {
+	GeoHash  string `json:"geohash"`
+	Date     string `json:"date"`
+	Acquired string `json:"acquired"`
+	Stage    string `json:"stage"`
+	File     string `json:"file"`
+	Error    string `json:"error"`
+}
+
+// errorReport writes a record for each failed tile as CSV or JSON lines.
+type errorReport struct {
+	file       *os.File
+	csvWriter  *csv.Writer
+	jsonWriter *json.Encoder
+}
+
+// Creates the errors file.  If no format is supplied it is inferred from the file extension.
+func newErrorReport(filePath string, format string) (*errorReport, error) {
+	if format == "" {
+		format = errorsFormatCSV
+		ext := strings.ToLower(path.Ext(filePath))
+		if ext == ".jsonl" || ext == ".json" {
+			format = errorsFormatJSONL
+		}
+	}
+	if format != errorsFormatCSV && format != errorsFormatJSONL {
+		return nil, errors.Errorf("unrecognized errors file format %s", format)
+	}
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", filePath)
+	}
+
+	report := &errorReport{file: file}
+	if format == errorsFormatJSONL {
+		report.jsonWriter = json.NewEncoder(file)
+		return report, nil
+	}
+
+	report.csvWriter = csv.NewWriter(file)
+	if err := report.csvWriter.Write([]string{"geohash", "date", "acquired", "stage", "file", "error"}); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "could not write errors file header")
+	}
+	return report, nil
+}
+
+// Writes the record for a failed tile.
+func (r *errorReport) write(result analytics.TileValues) error {
+	record := errorRecord{
+		GeoHash:  result.Tile.GeoHash,
+		Date:     formatDate(result.Tile),
+		Acquired: formatAcquired(result.Tile),
+		Stage:    analytics.StageSetup,
+		Error:    result.Err.Error(),
+	}
+
+	var tileErr *analytics.TileError
+	if errors.As(result.Err, &tileErr) {
+		record.Stage = tileErr.Stage
+		record.Error = tileErr.Err.Error()
+	}
+	var fileErr *analytics.FileError
+	if errors.As(result.Err, &fileErr) {
+		record.File = fileErr.Path
+	}
+
+	if r.jsonWriter != nil {
+		return r.jsonWriter.Encode(record)
+	}
+	return r.csvWriter.Write([]string{record.GeoHash, record.Date, record.Acquired, record.Stage, record.File,
+		record.Error})
+}
+
+// Flushes and closes the errors file.
+func (r *errorReport) close() {
+	if r.csvWriter != nil {
+		r.csvWriter.Flush()
+	}
+	if err := r.file.Close(); err != nil {
+		log.Warnf("failed to close errors file: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// Returns the failed tile results written by the error report tests.
+func failedResults() []analytics.TileValues {
+	tile := analytics.Tile{GeoHash: "9q8yy", Date: "20200101T103000", Timestamp: 1577874600}
+	fileErr := &analytics.FileError{Band: "B04", Path: "/tiles/9q8yy_20200101T103000_B04.tif",
+		Err: errors.New("no such file")}
+	return []analytics.TileValues{
+		{Tile: tile, Err: &analytics.TileError{Stage: analytics.StageSetup, Err: fileErr}},
+		{Tile: tile, Err: &analytics.TileError{Stage: analytics.StageTransform, Err: errors.New("no valid pixels")}},
+		{Tile: tile, Err: errors.New("failed")},
+	}
+}
+
+// Writes the failed results to an error report, returning its contents.
+func writeErrorReport(t *testing.T, fileName string, format string) string {
+	outputDir, err := ioutil.TempDir("", "errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	filePath := path.Join(outputDir, fileName)
+	report, err := newErrorReport(filePath, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range failedResults() {
+		if err := report.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report.close()
+
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestErrorReportCSV(t *testing.T) {
+	want := "geohash,date,acquired,stage,file,error\n" +
+		"9q8yy,2020-01-01,2020-01-01T10:30:00.000Z,setup,/tiles/9q8yy_20200101T103000_B04.tif," +
+		"B04 file not loaded: no such file\n" +
+		"9q8yy,2020-01-01,2020-01-01T10:30:00.000Z,transform,,no valid pixels\n" +
+		"9q8yy,2020-01-01,2020-01-01T10:30:00.000Z,setup,,failed\n"
+	// an explicit format overrides the extension
+	if got := writeErrorReport(t, "errors.json", errorsFormatCSV); got != want {
+		t.Errorf("wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestErrorReportJSONL(t *testing.T) {
+	// the format is inferred from the extension
+	lines := strings.Split(strings.TrimSpace(writeErrorReport(t, "errors.jsonl", "")), "\n")
+	want := []errorRecord{
+		{GeoHash: "9q8yy", Date: "2020-01-01", Acquired: "2020-01-01T10:30:00.000Z", Stage: analytics.StageSetup,
+			File: "/tiles/9q8yy_20200101T103000_B04.tif", Error: "B04 file not loaded: no such file"},
+		{GeoHash: "9q8yy", Date: "2020-01-01", Acquired: "2020-01-01T10:30:00.000Z",
+			Stage: analytics.StageTransform, Error: "no valid pixels"},
+		{GeoHash: "9q8yy", Date: "2020-01-01", Acquired: "2020-01-01T10:30:00.000Z", Stage: analytics.StageSetup,
+			Error: "failed"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("%d records, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var record errorRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record != want[i] {
+			t.Errorf("record %d is %+v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestNewErrorReportFormat(t *testing.T) {
+	if _, err := newErrorReport(path.Join(os.TempDir(), "errors.txt"), "xml"); err == nil {
+		t.Error("expected an error for an unrecognized format")
+	}
+}
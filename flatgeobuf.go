@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// FlatGeobuf format constants (see https://github.com/flatgeobuf/flatgeobuf/tree/master/src/fbs)
+const (
+	fgbGeometryTypePolygon = 3
+	fgbColumnTypeDouble    = 10
+	fgbColumnTypeString    = 11
+	fgbColumnTypeDateTime  = 13
+
+	// header table fields
+	fgbHeaderName          = 0
+	fgbHeaderGeometryType  = 2
+	fgbHeaderColumns       = 7
+	fgbHeaderIndexNodeSize = 9
+	fgbHeaderCrs           = 10
+	fgbHeaderFields        = 14
+
+	// column table fields
+	fgbColumnName   = 0
+	fgbColumnType   = 1
+	fgbColumnFields = 11
+
+	// crs table fields
+	fgbCrsOrg    = 0
+	fgbCrsCode   = 1
+	fgbCrsFields = 6
+
+	// geometry table fields
+	fgbGeometryXY     = 1
+	fgbGeometryFields = 8
+
+	// feature table fields
+	fgbFeatureGeometry   = 0
+	fgbFeatureProperties = 1
+	fgbFeatureFields     = 3
+
+	// the default index node size, which is written explicitly to disable the spatial index
+	fgbDefaultIndexNodeSize = 16
+
+	wgs84EPSGCode = 4326
+)
+
+// FlatGeobuf file signature - the magic bytes followed by the major version and patch number.
+var fgbMagicBytes = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// flatGeobufResultWriter writes a polygon feature for each tile, with the tile id, date, acquisition time,
+// optional crs and values as properties.  Features are streamed to the file, so the spatial index and feature count
+// are not written.
+type flatGeobufResultWriter struct {
+	file    *os.File
+	writer  *bufio.Writer
+	builder *flatbuffers.Builder
+	crs     bool
+}
+
+func newFlatGeobufResultWriter(outputFile string, valueNames []string, crs bool) (*flatGeobufResultWriter, error) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create flatgeobuf file")
+	}
+	w := &flatGeobufResultWriter{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		builder: flatbuffers.NewBuilder(1024),
+		crs:     crs,
+	}
+
+	if _, err := w.writer.Write(fgbMagicBytes); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to write flatgeobuf file")
+	}
+	if err := w.writeSizePrefixed(w.buildHeader(valueNames)); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to write flatgeobuf header")
+	}
+	return w, nil
+}
+
+// Builds the header describing the tile_id, date, acquired, crs and value columns.
+func (w *flatGeobufResultWriter) buildHeader(valueNames []string) []byte {
+	b := w.builder
+	b.Reset()
+
+	columnTypes := []byte{fgbColumnTypeString, fgbColumnTypeDateTime, fgbColumnTypeDateTime}
+	columnNames := []string{"tile_id", "date", "acquired"}
+	if w.crs {
+		columnTypes = append(columnTypes, fgbColumnTypeString)
+		columnNames = append(columnNames, "crs")
+	}
+	for range valueNames {
+		columnTypes = append(columnTypes, fgbColumnTypeDouble)
+	}
+	columnNames = append(columnNames, valueNames...)
+
+	columns := make([]flatbuffers.UOffsetT, len(columnNames))
+	for i, name := range columnNames {
+		nameOffset := b.CreateString(name)
+		b.StartObject(fgbColumnFields)
+		b.PrependUOffsetTSlot(fgbColumnName, nameOffset, 0)
+		b.PrependByteSlot(fgbColumnType, columnTypes[i], 0)
+		columns[i] = b.EndObject()
+	}
+	columnsOffset := createOffsetVector(b, columns)
+
+	orgOffset := b.CreateString("EPSG")
+	b.StartObject(fgbCrsFields)
+	b.PrependUOffsetTSlot(fgbCrsOrg, orgOffset, 0)
+	b.PrependInt32Slot(fgbCrsCode, wgs84EPSGCode, 0)
+	crsOffset := b.EndObject()
+
+	nameOffset := b.CreateString("tiles")
+	b.StartObject(fgbHeaderFields)
+	b.PrependUOffsetTSlot(fgbHeaderName, nameOffset, 0)
+	b.PrependByteSlot(fgbHeaderGeometryType, fgbGeometryTypePolygon, 0)
+	b.PrependUOffsetTSlot(fgbHeaderColumns, columnsOffset, 0)
+	b.PrependUint16Slot(fgbHeaderIndexNodeSize, 0, fgbDefaultIndexNodeSize)
+	b.PrependUOffsetTSlot(fgbHeaderCrs, crsOffset, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+// Writes a feature with the tile's bounds as its geometry.  Properties are encoded as the column index
+// followed by the value, with strings prefixed by their length.
+func (w *flatGeobufResultWriter) write(result analytics.TileValues) error {
+	b := w.builder
+	b.Reset()
+
+	properties := make([]byte, 0, 64+10*len(result.Values))
+	properties = appendFGBString(properties, 0, result.Tile.GeoHash)
+	properties = appendFGBString(properties, 1, formatDate(result.Tile))
+	properties = appendFGBString(properties, 2, formatAcquired(result.Tile))
+	column := uint16(3)
+	if w.crs {
+		properties = appendFGBString(properties, column, result.CRS)
+		column++
+	}
+	for i, value := range result.Values {
+		properties = appendUint16(properties, column+uint16(i))
+		properties = appendUint64(properties, math.Float64bits(value))
+	}
+	propertiesOffset := b.CreateByteVector(properties)
+
+	ring := result.Bounds.Ring()
+	b.StartVector(8, 2*len(ring), 8)
+	for i := len(ring) - 1; i >= 0; i-- {
+		b.PrependFloat64(ring[i][1])
+		b.PrependFloat64(ring[i][0])
+	}
+	xyOffset := b.EndVector(2 * len(ring))
+
+	b.StartObject(fgbGeometryFields)
+	b.PrependUOffsetTSlot(fgbGeometryXY, xyOffset, 0)
+	geometryOffset := b.EndObject()
+
+	b.StartObject(fgbFeatureFields)
+	b.PrependUOffsetTSlot(fgbFeatureGeometry, geometryOffset, 0)
+	b.PrependUOffsetTSlot(fgbFeatureProperties, propertiesOffset, 0)
+	b.Finish(b.EndObject())
+
+	return errors.Wrap(w.writeSizePrefixed(b.FinishedBytes()), "failed to write flatgeobuf feature")
+}
+
+func (w *flatGeobufResultWriter) close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write flatgeobuf file")
+	}
+	return w.file.Close()
+}
+
+// Writes a flatbuffer preceded by its little endian length.
+func (w *flatGeobufResultWriter) writeSizePrefixed(buffer []byte) error {
+	if _, err := w.writer.Write(appendUint32(nil, uint32(len(buffer)))); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(buffer)
+	return err
+}
+
+// Creates a flatbuffer vector of table offsets.
+func createOffsetVector(b *flatbuffers.Builder, offsets []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	b.StartVector(4, len(offsets), 4)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+// Appends a string property as its column index, length and bytes.
+func appendFGBString(properties []byte, column uint16, value string) []byte {
+	properties = appendUint16(properties, column)
+	properties = appendUint32(properties, uint32(len(value)))
+	return append(properties, value...)
+}
+
+func appendUint16(buffer []byte, value uint16) []byte {
+	return append(buffer, byte(value), byte(value>>8))
+}
+
+func appendUint32(buffer []byte, value uint32) []byte {
+	return append(buffer, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func appendUint64(buffer []byte, value uint64) []byte {
+	return appendUint32(appendUint32(buffer, uint32(value)), uint32(value>>32))
+}
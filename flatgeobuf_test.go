@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// Returns the vtable slot of a table field.
+func fgbSlot(field int) flatbuffers.VOffsetT {
+	return flatbuffers.VOffsetT(4 + 2*field)
+}
+
+// Returns the offset of a table field, or 0 if it isn't set.
+func fgbField(table *flatbuffers.Table, field int) flatbuffers.UOffsetT {
+	return flatbuffers.UOffsetT(table.Offset(fgbSlot(field)))
+}
+
+// Returns the table referenced by a field.
+func fgbTable(table *flatbuffers.Table, field int) *flatbuffers.Table {
+	return &flatbuffers.Table{Bytes: table.Bytes, Pos: table.Indirect(table.Pos + fgbField(table, field))}
+}
+
+// Reads a size prefixed flatbuffer from the start of the data, returning its root table and the
+// remaining data.
+func readSizePrefixed(t *testing.T, data []byte) (*flatbuffers.Table, []byte) {
+	if len(data) < 4 {
+		t.Fatal("missing size prefix")
+	}
+	size := binary.LittleEndian.Uint32(data)
+	buffer := data[4 : 4+size]
+	return &flatbuffers.Table{Bytes: buffer, Pos: flatbuffers.GetUOffsetT(buffer)}, data[4+size:]
+}
+
+func TestFlatGeobufResultWriter(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "fgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+	outputFile := path.Join(outputDir, "tiles.fgb")
+
+	w, err := newFlatGeobufResultWriter(outputFile, []string{"ndvi", "valid_fraction"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := analytics.TileValues{
+		Tile:   analytics.Tile{GeoHash: "9q8yy", Timestamp: 1577836800},
+		Bounds: analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38},
+		CRS:    "EPSG:32610",
+		Values: []float64{0.25, math.NaN()},
+	}
+	if err := w.write(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, fgbMagicBytes) {
+		t.Fatalf("missing magic bytes")
+	}
+
+	// header
+	header, data := readSizePrefixed(t, data[len(fgbMagicBytes):])
+	if geometryType := header.GetByteSlot(fgbSlot(fgbHeaderGeometryType), 0); geometryType != fgbGeometryTypePolygon {
+		t.Errorf("geometry type %d, want polygon", geometryType)
+	}
+	if indexNodeSize := header.GetUint16Slot(fgbSlot(fgbHeaderIndexNodeSize), 16); indexNodeSize != 0 {
+		t.Errorf("index node size %d, want 0", indexNodeSize)
+	}
+	crs := fgbTable(header, fgbHeaderCrs)
+	if code := crs.GetInt32Slot(fgbSlot(fgbCrsCode), 0); code != wgs84EPSGCode {
+		t.Errorf("crs code %d, want %d", code, wgs84EPSGCode)
+	}
+
+	wantNames := []string{"tile_id", "date", "acquired", "crs", "ndvi", "valid_fraction"}
+	wantTypes := []byte{fgbColumnTypeString, fgbColumnTypeDateTime, fgbColumnTypeDateTime, fgbColumnTypeString,
+		fgbColumnTypeDouble, fgbColumnTypeDouble}
+	columns := header.Vector(fgbField(header, fgbHeaderColumns))
+	if count := header.VectorLen(fgbField(header, fgbHeaderColumns)); count != len(wantNames) {
+		t.Fatalf("%d columns, want %d", count, len(wantNames))
+	}
+	for i := range wantNames {
+		column := &flatbuffers.Table{Bytes: header.Bytes, Pos: header.Indirect(columns + flatbuffers.UOffsetT(4*i))}
+		name := string(column.ByteVector(column.Pos + fgbField(column, fgbColumnName)))
+		columnType := column.GetByteSlot(fgbSlot(fgbColumnType), 0)
+		if name != wantNames[i] || columnType != wantTypes[i] {
+			t.Errorf("column %d is %s of type %d, want %s of type %d", i, name, columnType, wantNames[i], wantTypes[i])
+		}
+	}
+
+	// feature
+	feature, data := readSizePrefixed(t, data)
+	if len(data) != 0 {
+		t.Errorf("%d bytes after the feature", len(data))
+	}
+	geometry := fgbTable(feature, fgbFeatureGeometry)
+	xyOffset := fgbField(geometry, fgbGeometryXY)
+	xy := geometry.Vector(xyOffset)
+	ring := result.Bounds.Ring()
+	if count := geometry.VectorLen(xyOffset); count != 2*len(ring) {
+		t.Fatalf("%d coordinates, want %d", count, 2*len(ring))
+	}
+	for i, point := range ring {
+		x := flatbuffers.GetFloat64(geometry.Bytes[xy+flatbuffers.UOffsetT(16*i):])
+		y := flatbuffers.GetFloat64(geometry.Bytes[xy+flatbuffers.UOffsetT(16*i+8):])
+		if x != point[0] || y != point[1] {
+			t.Errorf("point %d is (%v, %v), want (%v, %v)", i, x, y, point[0], point[1])
+		}
+	}
+
+	properties := feature.ByteVector(feature.Pos + fgbField(feature, fgbFeatureProperties))
+	want := appendFGBString(nil, 0, "9q8yy")
+	want = appendFGBString(want, 1, "2020-01-01")
+	want = appendFGBString(want, 2, "2020-01-01T00:00:00.000Z")
+	want = appendFGBString(want, 3, "EPSG:32610")
+	want = appendUint16(want, 4)
+	want = appendUint64(want, math.Float64bits(0.25))
+	want = appendUint16(want, 5)
+	want = appendUint64(want, math.Float64bits(math.NaN()))
+	if !bytes.Equal(properties, want) {
+		t.Errorf("properties %v, want %v", properties, want)
+	}
+}
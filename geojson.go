@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// geoJSONResultWriter writes a FeatureCollection with a polygon feature for each tile.  Features are
+// streamed to the file as they are written, so the collection is only complete once the writer is closed.
+type geoJSONResultWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	// JSON encoded property names for the values
+	names        [][]byte
+	crs          bool
+	wroteFeature bool
+}
+
+func newGeoJSONResultWriter(outputFile string, valueNames []string, crs bool) (*geoJSONResultWriter, error) {
+	names := make([][]byte, len(valueNames))
+	for i, name := range valueNames {
+		encoded, err := json.Marshal(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode value name %s", name)
+		}
+		names[i] = encoded
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create geojson file")
+	}
+	w := &geoJSONResultWriter{file: file, writer: bufio.NewWriter(file), names: names, crs: crs}
+	if _, err := w.writer.WriteString(`{"type":"FeatureCollection","features":[`); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to write geojson file")
+	}
+	return w, nil
+}
+
+// Writes a feature with the tile's bounds as its geometry, and the tile id, date, acquisition time,
+// optional crs and values as its properties.  NaN values are written as null.
+func (w *geoJSONResultWriter) write(result analytics.TileValues) error {
+	buffer := make([]byte, 0, 256)
+	if w.wroteFeature {
+		buffer = append(buffer, ',')
+	}
+	buffer = append(buffer, "\n"+`{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[`...)
+	for i, point := range result.Bounds.Ring() {
+		if i > 0 {
+			buffer = append(buffer, ',')
+		}
+		buffer = append(buffer, '[')
+		buffer = appendJSONFloat(buffer, point[0])
+		buffer = append(buffer, ',')
+		buffer = appendJSONFloat(buffer, point[1])
+		buffer = append(buffer, ']')
+	}
+	buffer = append(buffer, `]]},"properties":{"tile_id":`...)
+	tileID, err := json.Marshal(result.Tile.GeoHash)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode tile id")
+	}
+	buffer = append(buffer, tileID...)
+	buffer = append(buffer, `,"date":"`...)
+	buffer = append(buffer, formatDate(result.Tile)...)
+	buffer = append(buffer, `","acquired":"`...)
+	buffer = append(buffer, formatAcquired(result.Tile)...)
+	buffer = append(buffer, '"')
+	if w.crs {
+		crs, err := json.Marshal(result.CRS)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode crs")
+		}
+		buffer = append(buffer, `,"crs":`...)
+		buffer = append(buffer, crs...)
+	}
+	for i, value := range result.Values {
+		buffer = append(buffer, ',')
+		buffer = append(buffer, w.names[i]...)
+		buffer = append(buffer, ':')
+		buffer = appendJSONFloat(buffer, value)
+	}
+	buffer = append(buffer, "}}"...)
+
+	if _, err := w.writer.Write(buffer); err != nil {
+		return errors.Wrap(err, "failed to write geojson feature")
+	}
+	w.wroteFeature = true
+	return nil
+}
+
+// Terminates the feature collection and closes the file.
+func (w *geoJSONResultWriter) close() error {
+	if _, err := w.writer.WriteString("\n]}\n"); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write geojson file")
+	}
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write geojson file")
+	}
+	return w.file.Close()
+}
+
+// Appends a float as a JSON number, or null if it can't be represented in JSON.
+func appendJSONFloat(buffer []byte, value float64) []byte {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return append(buffer, "null"...)
+	}
+	return strconv.AppendFloat(buffer, value, 'f', -1, 64)
+}
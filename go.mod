@@ -3,12 +3,16 @@ module github.com/uncharted-distil/tile-tx
 go 1.13
 
 require (
+	github.com/google/flatbuffers v1.12.1
 	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/tidwall/gjson v1.6.8
 	github.com/tidwall/pretty v1.0.5 // indirect
 	github.com/uncharted-distil/gdal v0.0.0-20200504224203-25f2e6a0dc2a
 	github.com/unchartedsoftware/plog v0.0.0-20170413154239-34d2bbd3c0a9
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	golang.org/x/sys v0.0.0-20201009025420-dfb3f7c4e634 // indirect
 )
@@ -1,16 +1,16 @@
 package main
 
 import (
-	"encoding/csv"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,180 +22,394 @@ const (
 	metadataFileName = "metadata.json"
 )
 
+// config holds the options parsed from the command line.
+type config struct {
+	inputDir       string
+	outputFile     string
+	format         string
+	layout         string
+	crs            bool
+	operations     []analytics.Operation
+	options        analytics.Options
+	pipeline       pipelineOptions
+	resume         bool
+	previous       string
+	errorsFile     string
+	errorsFormat   string
+	listOperations bool
+}
+
 func main() {
-	inputDir := flag.String("input", ".", "Input directory containing geotiff files.")
-	outputFile := flag.String("output", ".", "Output file path.")
-	operation := flag.String("operation", "mean_NDVI", "Operation to perform on the tiles.")
-	workers := *flag.Int("workers", 8, "number of workers")
-	flag.Parse()
+	cfg, err := parseConfig()
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	if cfg.listOperations {
+		printOperations(os.Stdout)
+		return
+	}
+
+	// deferred calls in run (ie. closing the errors file) are made before exiting
+	if err := run(cfg); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
 
+// Applies the operations to the tiles in the input directory, writing the results to the output file.
+func run(cfg config) error {
 	// Load the metadata associated with the tile dataset
-	metadata, err := loadMetadata(*inputDir)
+	metadata, err := loadMetadata(cfg.inputDir)
 	if err != nil {
-		log.Error(err, "could not load dataset metadata")
-		os.Exit(1)
+		return errors.Wrap(err, "could not load dataset metadata")
 	}
 
 	// Instantiate a tile analytic based on the operation specified in the command line params
-	tileAnalytic, err := analytics.CreateTileAnalytic(metadata, analytics.Operation(*operation))
+	tileAnalytics, err := createTileAnalytics(metadata, cfg.operations, cfg.options)
 	if err != nil {
-		log.Error(err, "could initialize tile analytic")
-		os.Exit(1)
+		return errors.Wrap(err, "could initialize tile analytic")
 	}
 
-	// Initialize output CSV file
-	dir := path.Dir(*outputFile)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			log.Error(err, "failed to create output directory")
-			os.Exit(1)
-		}
+	if err := createOutputDir(cfg.outputFile); err != nil {
+		return err
 	}
-	csvFile, err := os.Create(*outputFile)
+	names := valueNames(cfg.operations, tileAnalytics)
+
+	previous, completed, err := readPreviousOutput(cfg, tileAnalytics, names)
 	if err != nil {
-		log.Error("failed to create csv file")
-		os.Exit(1)
+		return err
 	}
-	defer csvFile.Close()
+	cfg.pipeline.completed = completed
 
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush()
+	// Initialize the failed tile report
+	var report *errorReport
+	if cfg.errorsFile != "" {
+		report, err = newErrorReport(cfg.errorsFile, cfg.errorsFormat)
+		if err != nil {
+			return errors.Wrap(err, "failed to create errors file")
+		}
+		defer report.close()
+	}
 
-	// write the header row
-	err = csvWriter.Write(append([]string{"tile_id", "date", "bounds"}, tileAnalytic.ValueNames()...))
+	output, err := newResultWriter(cfg.outputFile, names, outputOptions{
+		format:   cfg.format,
+		layout:   cfg.layout,
+		previous: previous,
+		crs:      cfg.crs,
+	})
 	if err != nil {
-		log.Error(err, "could not write csv header")
-		os.Exit(1)
+		return errors.Wrap(err, "failed to create output file")
 	}
 
-	// generate row data from tiles
-	rows := processTiles(workers, *inputDir, tileAnalytic)
+	// generate row data from tiles, writing out results as they are completed
+	summary := errorSummary{}
+	err = processTiles(cfg.inputDir, tileAnalytics, cfg.pipeline, resultHandler(output, report, &summary))
+	summary.log()
 
-	// write out results
-	for _, row := range rows {
-		if err = csvWriter.Write(row); err != nil {
-			continue
-		}
+	// keep the rows that were completed before any failure
+	if closeErr := output.close(); closeErr != nil {
+		return errors.Wrap(closeErr, "failed to write output file")
 	}
+	return errors.Wrap(err, "failed to process tiles")
 }
 
-// apply analytic operation to tiles and write results out as a row data
-func processTiles(workers int, inputDir string, tileAnalytic analytics.Transformer) [][]string {
-	// Scan the input dir and collect tile information by parsing each file name
-	tileMap, err := createTileMap(inputDir)
-	if err != nil {
-		log.Warnf("failed to read tile information")
-		os.Exit(1)
+// Returns a handler writing completed tiles to the output, and failed tiles to the summary and report.
+func resultHandler(output resultWriter, report *errorReport, summary *errorSummary) func(analytics.TileValues) {
+	return func(result analytics.TileValues) {
+		if result.Err != nil {
+			summary.add(result.Err)
+			if report != nil {
+				if err := report.write(result); err != nil {
+					log.Warnf("failed to write error record for %s_%s: %v", result.Tile.GeoHash, result.Tile.Date, err)
+				}
+			}
+			return
+		}
+		if err := output.write(result); err != nil {
+			log.Warnf("failed to write row for %s_%s: %v", result.Tile.GeoHash, result.Tile.Date, err)
+		}
 	}
+}
 
-	// flatten tilemap to an array
-	tileArray := []analytics.Tile{}
-	for _, tiles := range tileMap {
-		tileArray = append(tileArray, tiles...)
+// Creates the directory of the output file if it doesn't exist.
+func createOutputDir(outputFile string) error {
+	dir := path.Dir(outputFile)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return errors.Wrap(err, "failed to create output directory")
+		}
 	}
+	return nil
+}
 
-	results := make(chan []string, len(tileArray))
-	tiles := make(chan analytics.Tile, len(tileArray))
-
-	var wg sync.WaitGroup
-	wg.Add(workers)
+// Parses and validates the command line flags.
+func parseConfig() (config, error) {
+	inputDir := flag.String("input", ".", "Input directory containing geotiff files.")
+	outputFile := flag.String("output", ".", "Output file path.")
+	format := flag.String("format", formatCSV,
+		"Output file format (csv, parquet, geojson, flatgeobuf, d3m, sqlite, gpkg).  "+
+			"The d3m output path is a dataset directory.")
+	operation := flag.String("operation", analytics.OperationMeanNDVI,
+		"Operation to perform on the tiles.  Separate multiple per-tile operations with commas.")
+	expression := flag.String("expression", "",
+		"Band math formula for the expression operation (ie. \"(B08-B04)/(B08+B04)\").")
+	aggregation := flag.String("aggregation", analytics.AggregationMean,
+		"Aggregation applied to expression values (mean, median, sum).")
+	percentiles := flag.String("percentiles", "5,25,75,95",
+		"Comma separated percentiles computed by the stats operation.  Repeated percentiles are rejected.")
+	cloudMask := flag.String("cloud-mask", analytics.CloudMaskNone,
+		"Band used to mask cloudy pixels (none, scl, qa60).")
+	source := flag.String("source", analytics.OperationMeanNDVI,
+		"Per-tile operation that temporal operations are applied to.")
+	stack := flag.String("stack", "",
+		"Name of a multi-band <geohash>_<date>_<name>.tif file holding the bands of each tile.")
+	bandMap := flag.String("band-map", "",
+		"Comma separated band=index pairs locating bands within the stacked file (ie. \"B04=4,B08=8\").  "+
+			"Bands that aren't mapped are found by index or by band description.")
+	rawValues := flag.Bool("raw-values", false,
+		"Use the raw values of each band rather than applying the band's scale and offset or converting to reflectance.")
+	harmonized := flag.Bool("harmonized", false,
+		"Sentinel-2 digital numbers have already had the processing baseline 04.00 offset removed.")
+	resampling := flag.String("resampling", analytics.ResamplingNearest,
+		"Method used to resample bands of different resolutions to a common grid (none, nearest, bilinear, average, mode).")
+	resolution := flag.Float64("resolution", 0,
+		"Pixel size of the common grid bands are resampled to.  Defaults to the resolution of the finest band.")
+	listOperations := flag.Bool("list-operations", false, "List the available operations and exit.")
+	ordered := flag.Bool("ordered", false, "Write rows in geohash, date order rather than as they are completed.")
+	onError := flag.String("on-error", "skip",
+		"Handling of failed tiles (skip, fail, fail-after=N to abort once N tiles have failed).")
+	resume := flag.Bool("resume", false,
+		"Resume an interrupted run, skipping the tiles already written to the output file and appending the rest.")
+	layout := flag.String("layout", layoutWide,
+		"Output layout (wide for a column per value, long for a row per tile, date and value).")
+	crs := flag.Bool("crs", false, "Add a column identifying the coordinate system of each tile's rasters.")
+	previousOutput := flag.String("previous", "",
+		"Previous output file.  Only tiles missing from it are processed, and they are merged with its rows.")
+	errorsFile := flag.String("errors-file", "", "Optional file to write a record of each failed tile to.")
+	errorsFormat := flag.String("errors-format", "",
+		"Format of the errors file (csv, jsonl).  Defaults to jsonl for .jsonl and .json files, csv otherwise.")
+	workers := flag.Int("workers", 8, "number of workers")
+	flag.Parse()
 
-	// Start workers.  Mileage will vary given that IO is the bottleneck, and HDD
-	// reads don't parallelize.  SSD will allow for parallel reads, and you should
-	// get some OS level cacheing in either case if the tile data has been loaded recently.
-	for i := 0; i < workers; i++ {
-		go tileWorker(i, tiles, results, &wg, tileAnalytic, inputDir)
+	cfg := config{
+		inputDir:   *inputDir,
+		outputFile: *outputFile,
+		format:     *format,
+		layout:     *layout,
+		crs:        *crs,
+		operations: parseOperations(*operation),
+		options: analytics.Options{
+			Expression:  *expression,
+			Aggregation: analytics.Aggregation(*aggregation),
+			CloudMask:   analytics.CloudMaskSource(*cloudMask),
+			Source:      analytics.Operation(*source),
+		},
+		pipeline: pipelineOptions{
+			workers: *workers,
+			ordered: *ordered,
+			input: analytics.InputOptions{
+				RawValues:  *rawValues,
+				Harmonized: *harmonized,
+				Resampling: analytics.Resampling(*resampling),
+				Resolution: *resolution,
+			},
+		},
+		resume:         *resume,
+		previous:       *previousOutput,
+		errorsFile:     *errorsFile,
+		errorsFormat:   *errorsFormat,
+		listOperations: *listOperations,
 	}
-
-	// Send all of the tiles to the workers
-	for _, tile := range tileArray {
-		tiles <- tile
+	if cfg.listOperations {
+		return cfg, nil
 	}
-	close(tiles)
 
-	// Wait for workers to finish
-	go func() {
-		defer close(results)
-		wg.Wait()
-	}()
+	var err error
+	cfg.pipeline.policy, err = parseErrorPolicy(*onError)
+	if err != nil {
+		return cfg, errors.Wrap(err, "could not parse error policy")
+	}
+	cfg.options.Percentiles, err = parsePercentiles(*percentiles)
+	if err != nil {
+		return cfg, errors.Wrap(err, "could not parse percentiles")
+	}
+	cfg.pipeline.input.Stack, err = parseStack(*stack, *bandMap)
+	if err != nil {
+		return cfg, err
+	}
+	if err := validateResampling(cfg.pipeline.input.Resampling); err != nil {
+		return cfg, err
+	}
+	return cfg, validatePrevious(cfg)
+}
 
-	// Collect the results
-	rows := [][]string{}
-	for r := range results {
-		rows = append(rows, r)
+// Returns the stacked file that bands are read from, or nil if each band is read from its own file.
+func parseStack(stack string, bandMap string) (*analytics.BandStack, error) {
+	if stack == "" {
+		return nil, nil
 	}
+	bands, err := parseBandMap(bandMap)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse band map")
+	}
+	return &analytics.BandStack{Name: stack, Bands: bands}, nil
+}
 
-	return rows
+func validateResampling(resampling analytics.Resampling) error {
+	switch resampling {
+	case analytics.ResamplingNone, analytics.ResamplingNearest, analytics.ResamplingBilinear,
+		analytics.ResamplingAverage, analytics.ResamplingMode:
+		return nil
+	default:
+		return errors.Errorf("unrecognized resampling method %s", resampling)
+	}
 }
 
-// Processes a tile batch.
-func tileWorker(worker int, tiles chan analytics.Tile, results chan []string,
-	wg *sync.WaitGroup, tileAnalytic analytics.Transformer, inputDir string) {
+// Checks that the output can be resumed or merged with the previous output.
+func validatePrevious(cfg config) error {
+	if !cfg.resume && cfg.previous == "" {
+		return nil
+	}
+	if cfg.resume && cfg.previous != "" {
+		return errors.New("resume and previous can't be combined")
+	}
+	if !outputFormats[cfg.format].previous {
+		return errors.Errorf("%s output can't be resumed or merged with a previous output", cfg.format)
+	}
+	if cfg.resume && cfg.layout == layoutLong {
+		// an interrupted run may have written only some of the rows of a tile
+		return errors.New("long layout output can't be resumed")
+	}
+	return nil
+}
 
-	setupErrCount := 0
-	var lastSetupErr error
+// When resuming or updating a previous output, finds the tiles that have already been written.  Returns the
+// previous output, which is appended to when it is the output file and otherwise has its rows copied first,
+// along with the keys of its tiles.
+func readPreviousOutput(cfg config, tileAnalytics []analytics.Analytic,
+	names []string) (string, map[string]bool, error) {
+	previous := cfg.previous
+	if cfg.resume {
+		var err error
+		previous, err = resumeOutput(cfg.outputFile)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "could not repair existing output")
+		}
+	}
+	if previous == "" {
+		return "", nil, nil
+	}
 
-	transformErrCount := 0
-	var lastTransformErr error
+	if _, ok := tileAnalytics[0].(analytics.TemporalTransformer); ok {
+		return "", nil, errors.New("temporal operations can't be resumed or merged with a previous output")
+	}
+	completed, err := readCompletedTiles(previous, csvHeader(names, cfg.layout, cfg.crs))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not read previous output")
+	}
+	log.Infof("%d tiles already completed", len(completed))
+	return previous, completed, nil
+}
 
-	for tile := range tiles {
-		count := 0
-		count++
-		if count%100 == 0 {
-			log.Infof("worker %d: processed %d", worker, count)
+// Parses a comma separated list of operations.
+func parseOperations(operations string) []analytics.Operation {
+	parsed := []analytics.Operation{}
+	for _, operation := range strings.Split(operations, ",") {
+		operation = strings.TrimSpace(operation)
+		if operation == "" {
+			continue
 		}
+		parsed = append(parsed, analytics.Operation(operation))
+	}
+	return parsed
+}
 
-		// Load the required tile images and run the tile transform on them.
-		images, err := tileAnalytic.Setup(inputDir, &tile)
-		if err != nil {
-			setupErrCount++
-			lastSetupErr = err
-			continue
+// Creates a tile analytic for each operation.  Temporal operations can't be combined with others
+// since they generate rows from the full series of tiles for a geohash, and operations can't be repeated
+// since their values would have the same names.
+func createTileAnalytics(metadata analytics.JSONString, operations []analytics.Operation,
+	options analytics.Options) ([]analytics.Analytic, error) {
+	if len(operations) == 0 {
+		return nil, errors.New("no operation specified")
+	}
+
+	tileAnalytics := make([]analytics.Analytic, len(operations))
+	created := map[analytics.Operation]bool{}
+	for i, operation := range operations {
+		if created[operation] {
+			return nil, errors.Errorf("operation %s is repeated", operation)
 		}
-		values, err := tileAnalytic.Transform(images)
+		created[operation] = true
+		if _, ok := analytics.Lookup(operation); !ok {
+			return nil, errors.Errorf("unrecognized operation %s (run with -list-operations to list them)", operation)
+		}
+		tileAnalytic, err := analytics.CreateTileAnalytic(metadata, operation, options)
 		if err != nil {
-			transformErrCount++
-			lastTransformErr = err
-			continue
+			return nil, errors.Wrapf(err, "failed to create operation %s", operation)
 		}
-
-		// Reformat the results
-		formattedValues := make([]string, len(values))
-		for i, value := range values {
-			formattedValues[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		if _, ok := tileAnalytic.(analytics.Transformer); !ok && len(operations) > 1 {
+			return nil, errors.Errorf("temporal operation %s can't be combined with other operations", operation)
 		}
+		tileAnalytics[i] = tileAnalytic
+	}
+	return tileAnalytics, nil
+}
 
-		// Reformat the tile timestamp to YYYY-MM-DD.
-		date := time.Unix(tile.Timestamp, 0).Format("2006-01-02")
-
-		// Extract the geobounds from the first image
-		geoBounds := images[0].Bounds
-
-		row := []string{tile.GeoHash, date, geoBounds.String()}
-		row = append(row, formattedValues...)
-
-		results <- row
+// Returns the concatenated value names of the analytics.  Names generated by more than one analytic
+// (ie. valid_fraction) are prefixed with their operation name to keep them unique.
+func valueNames(operations []analytics.Operation, tileAnalytics []analytics.Analytic) []string {
+	nameCounts := map[string]int{}
+	for _, tileAnalytic := range tileAnalytics {
+		for _, name := range tileAnalytic.ValueNames() {
+			nameCounts[name]++
+		}
 	}
 
-	log.Infof("worker %d: tile processing complete", worker)
+	names := []string{}
+	for i, tileAnalytic := range tileAnalytics {
+		for _, name := range tileAnalytic.ValueNames() {
+			if nameCounts[name] > 1 {
+				name = fmt.Sprintf("%s_%s", operations[i], name)
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
 
-	if setupErrCount > 0 {
-		log.Warnf("encountered %d setup errors", setupErrCount)
-		log.Warnf("last setup error: %s", lastSetupErr)
+// Formats the values generated for a tile as a row of output data, with the tile's coordinate system
+// following the bounds when crs is set.
+func formatRow(result analytics.TileValues, crs bool) []string {
+	// Reformat the results
+	formattedValues := make([]string, len(result.Values))
+	for i, value := range result.Values {
+		formattedValues[i] = strconv.FormatFloat(value, 'f', -1, 64)
 	}
 
-	if transformErrCount > 0 {
-		log.Warnf("encountered %d transform errors", transformErrCount)
-		log.Warnf("last transform error: %s", lastTransformErr)
+	row := []string{result.Tile.GeoHash, formatDate(result.Tile), formatAcquired(result.Tile), result.Bounds.String()}
+	if crs {
+		row = append(row, result.CRS)
 	}
+	return append(row, formattedValues...)
+}
+
+// Reformat the tile timestamp to YYYY-MM-DD in UTC.
+func formatDate(tile analytics.Tile) string {
+	return time.Unix(tile.Timestamp, 0).UTC().Format("2006-01-02")
+}
 
-	wg.Done()
+// Formats the tile timestamp as an ISO 8601 date and time in UTC (the GeoPackage DATETIME format), which
+// distinguishes the acquisitions of a geohash on the same day.
+func formatAcquired(tile analytics.Tile) string {
+	return time.Unix(tile.Timestamp, 0).UTC().Format("2006-01-02T15:04:05.000Z")
 }
 
 // Creates entries for tile data by parsing file names.  Entries are mapped
 // by a derived ID.
-func createTileMap(inputDir string) (map[string][]analytics.Tile, error) {
+func createTileMap(inputDir string, input analytics.InputOptions) (map[string][]analytics.Tile, error) {
 
 	log.Infof("scanning directory")
 
@@ -229,7 +443,7 @@ func createTileMap(inputDir string) (map[string][]analytics.Tile, error) {
 
 		// parse the date
 		dateString := splitPath[1]
-		layout := "20060102T030405"
+		layout := "20060102T150405"
 		date, err := time.Parse(layout, dateString)
 		if err != nil {
 			log.Warnf("cannot parse date %s", dateString)
@@ -249,6 +463,7 @@ func createTileMap(inputDir string) (map[string][]analytics.Tile, error) {
 			GeoHash:   id,
 			Date:      dateString,
 			Timestamp: date.Unix(),
+			Input:     input,
 		}
 		if _, ok := tileMap[id]; !ok {
 			tileMap[id] = []analytics.Tile{}
@@ -269,6 +484,65 @@ func loadMetadata(inputDir string) (analytics.JSONString, error) {
 	return analytics.JSONString(raw), nil
 }
 
+// Prints each registered operation along with its description and required bands.
+func printOperations(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tBANDS\tDESCRIPTION")
+	for _, name := range analytics.Operations() {
+		factory, _ := analytics.Lookup(name)
+		bands := strings.Join(factory.Bands, ",")
+		if bands == "" {
+			bands = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, bands, factory.Description)
+	}
+	tw.Flush()
+}
+
+// Parses a comma separated list of percentiles.  Repeated percentiles are rejected since they would
+// produce repeated stats columns.
+func parsePercentiles(percentiles string) ([]float64, error) {
+	parsed := []float64{}
+	seen := map[float64]bool{}
+	for _, p := range strings.Split(percentiles, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid percentile %s", p)
+		}
+		if seen[value] {
+			return nil, errors.Errorf("percentile %s is repeated", p)
+		}
+		seen[value] = true
+		parsed = append(parsed, value)
+	}
+	return parsed, nil
+}
+
+// Parses a comma separated list of band=index pairs.
+func parseBandMap(bandMap string) (map[string]int, error) {
+	parsed := map[string]int{}
+	for _, pair := range strings.Split(bandMap, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		split := strings.Split(pair, "=")
+		if len(split) != 2 {
+			return nil, errors.Errorf("invalid band mapping %s", pair)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(split[1]))
+		if err != nil || index < 1 {
+			return nil, errors.Errorf("invalid band index in %s", pair)
+		}
+		parsed[strings.TrimSpace(split[0])] = index
+	}
+	return parsed, nil
+}
+
 // Inserts a tile into list sorted by date.
 func insertSorted(tiles []analytics.Tile, t analytics.Tile) []analytics.Tile {
 	index := sort.Search(len(tiles), func(i int) bool { return tiles[i].Timestamp > t.Timestamp })
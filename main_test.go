@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+const testMetadata = analytics.JSONString(`{"bands": [{"id": "B08"}]}`)
+
+func TestCreateTileAnalyticsUnrecognized(t *testing.T) {
+	operations := parseOperations("mean, stat")
+	if _, err := createTileAnalytics(testMetadata, operations, analytics.Options{}); err == nil {
+		t.Error("expected an error for an unrecognized operation")
+	}
+}
+
+func TestCreateTileAnalyticsRepeated(t *testing.T) {
+	if _, err := createTileAnalytics(testMetadata, parseOperations("mean,mean"), analytics.Options{}); err == nil {
+		t.Error("expected an error for a repeated operation")
+	}
+
+	operations := parseOperations("mean,stats")
+	tileAnalytics, err := createTileAnalytics(testMetadata, operations, analytics.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := valueNames(operations, tileAnalytics)
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("value name %s is repeated in %v", name, names)
+		}
+		seen[name] = true
+	}
+	if !seen["mean_valid_fraction"] || !seen["stats_valid_fraction"] {
+		t.Errorf("valid fractions aren't prefixed in %v", fmt.Sprint(names))
+	}
+}
+
+func TestValidatePrevious(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config
+		fails bool
+	}{
+		{"new output", config{format: formatParquet, layout: layoutLong}, false},
+		{"resume", config{format: formatCSV, layout: layoutWide, resume: true}, false},
+		{"previous", config{format: formatCSV, layout: layoutLong, previous: "out.csv"}, false},
+		{"resume and previous", config{format: formatCSV, layout: layoutWide, resume: true, previous: "out.csv"}, true},
+		{"resume parquet", config{format: formatParquet, layout: layoutWide, resume: true}, true},
+		{"previous sqlite", config{format: formatSQLite, layout: layoutWide, previous: "out.db"}, true},
+		{"resume long layout", config{format: formatCSV, layout: layoutLong, resume: true}, true},
+	}
+	for _, test := range tests {
+		if err := validatePrevious(test.cfg); (err != nil) != test.fails {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	tests := []struct {
+		percentiles string
+		want        string
+		fails       bool
+	}{
+		{"5, 25,75,95", "[5 25 75 95]", false},
+		{"50,,99.5", "[50 99.5]", false},
+		{"", "[]", false},
+		{"50,x", "", true},
+		{"50,50", "", true},
+		{"50,50.0", "", true},
+	}
+	for _, test := range tests {
+		parsed, err := parsePercentiles(test.percentiles)
+		if (err != nil) != test.fails {
+			t.Errorf("%q: unexpected error %v", test.percentiles, err)
+			continue
+		}
+		if !test.fails && fmt.Sprint(parsed) != test.want {
+			t.Errorf("%q parsed as %v, want %s", test.percentiles, parsed, test.want)
+		}
+	}
+}
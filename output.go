@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	formatCSV        = "csv"
+	formatParquet    = "parquet"
+	formatGeoJSON    = "geojson"
+	formatFlatGeobuf = "flatgeobuf"
+	formatD3M        = "d3m"
+	formatSQLite     = "sqlite"
+	formatGeoPackage = "gpkg"
+
+	// output layouts - a column per value, or a row per value
+	layoutWide = "wide"
+	layoutLong = "long"
+
+	// number of goroutines used to encode parquet pages
+	parquetParallelism = 4
+)
+
+// characters that can't be used in parquet column names (ie. the separators of the schema metadata)
+var parquetInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// resultWriter writes the values generated for tiles to an output file.
+type resultWriter interface {
+	write(result analytics.TileValues) error
+	close() error
+}
+
+// outputOptions controls the format and layout of the output.
+type outputOptions struct {
+	format string
+	layout string
+	// previous output whose rows are kept
+	previous string
+	// adds a column identifying the coordinate system of each tile
+	crs bool
+}
+
+// outputFormat creates the writer for an output format, and describes the options it supports.
+type outputFormat struct {
+	create func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error)
+	// supports the long layout
+	long bool
+	// supports resuming or merging with a previous output
+	previous bool
+}
+
+// The supported output formats.
+var outputFormats = map[string]outputFormat{
+	formatCSV: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newCSVResultWriter(outputFile, valueNames, options.layout == layoutLong, options.crs,
+				options.previous)
+		},
+		long:     true,
+		previous: true,
+	},
+	formatParquet: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newParquetResultWriter(outputFile, valueNames, options.layout == layoutLong, options.crs)
+		},
+		long: true,
+	},
+	formatGeoJSON: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newGeoJSONResultWriter(outputFile, valueNames, options.crs)
+		},
+	},
+	formatFlatGeobuf: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newFlatGeobufResultWriter(outputFile, valueNames, options.crs)
+		},
+	},
+	formatD3M: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newD3MResultWriter(outputFile, valueNames, options.crs)
+		},
+	},
+	formatSQLite: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newSQLiteResultWriter(outputFile, valueNames, false, options.crs)
+		},
+	},
+	formatGeoPackage: {
+		create: func(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+			return newSQLiteResultWriter(outputFile, valueNames, true, options.crs)
+		},
+	},
+}
+
+// Creates a writer for an output format.  The previous output is only supported by the csv format, which
+// appends to it when it is the output file and copies its rows otherwise.  The long layout is supported by
+// the csv and parquet formats.
+func newResultWriter(outputFile string, valueNames []string, options outputOptions) (resultWriter, error) {
+	format, ok := outputFormats[options.format]
+	if !ok {
+		return nil, errors.Errorf("unrecognized output format %s", options.format)
+	}
+	if options.previous != "" && !format.previous {
+		return nil, errors.Errorf("%s output can't be resumed or merged with a previous output", options.format)
+	}
+	if options.layout != layoutWide && options.layout != layoutLong {
+		return nil, errors.Errorf("unrecognized output layout %s", options.layout)
+	}
+	if options.layout == layoutLong && !format.long {
+		return nil, errors.Errorf("%s output doesn't support the long layout", options.format)
+	}
+	return format.create(outputFile, valueNames, options)
+}
+
+// Returns the csv header for the value names in a layout.  The crs column follows the acquisition time in
+// the long layout, and the bounds in the wide layout.
+func csvHeader(valueNames []string, layout string, crs bool) []string {
+	if layout == layoutLong {
+		if crs {
+			return []string{"tile_id", "date", "acquired", "crs", "variable", "value"}
+		}
+		return []string{"tile_id", "date", "acquired", "variable", "value"}
+	}
+	header := []string{"tile_id", "date", "acquired", "bounds"}
+	if crs {
+		header = append(header, "crs")
+	}
+	return append(header, valueNames...)
+}
+
+// csvResultWriter writes a row of formatted values for each tile, or a row for each value in the long
+// layout.
+type csvResultWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	// value names written to the variable column of the long layout
+	longNames []string
+	crs       bool
+}
+
+func newCSVResultWriter(outputFile string, valueNames []string, long bool, crs bool,
+	previous string) (*csvResultWriter, error) {
+	appendOutput := previous != "" && sameFile(previous, outputFile)
+
+	var file *os.File
+	var err error
+	if appendOutput {
+		file, err = os.OpenFile(outputFile, os.O_APPEND|os.O_WRONLY, 0)
+	} else {
+		file, err = os.Create(outputFile)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create csv file")
+	}
+	w := &csvResultWriter{file: file, writer: csv.NewWriter(file), crs: crs}
+	layout := layoutWide
+	if long {
+		w.longNames = valueNames
+		layout = layoutLong
+	}
+	if appendOutput {
+		return w, nil
+	}
+
+	// write the header row, followed by the rows of the previous output
+	err = w.writer.Write(csvHeader(valueNames, layout, crs))
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "could not write csv header")
+	}
+	if previous != "" {
+		err = copyRows(previous, w.writer)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *csvResultWriter) write(result analytics.TileValues) error {
+	if w.longNames == nil {
+		return w.writer.Write(formatRow(result, w.crs))
+	}
+
+	key := []string{result.Tile.GeoHash, formatDate(result.Tile), formatAcquired(result.Tile)}
+	if w.crs {
+		key = append(key, result.CRS)
+	}
+	for i, value := range result.Values {
+		row := append(key[:len(key):len(key)], w.longNames[i], strconv.FormatFloat(value, 'f', -1, 64))
+		if err := w.writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *csvResultWriter) close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write csv file")
+	}
+	return w.file.Close()
+}
+
+// parquetResultWriter writes typed columns for each tile - the geohash as a string, the date as a DATE,
+// the acquisition time as a TIMESTAMP_MILLIS, the bounds as four double columns and a double column for
+// each value.  The long layout writes the geohash, date, acquisition time, value name and value for each
+// value instead.  The optional crs string column follows the bounds, or the acquisition time in the long
+// layout.
+type parquetResultWriter struct {
+	file   *os.File
+	writer *writer.CSVWriter
+	// value names written to the variable column of the long layout
+	longNames []string
+	crs       bool
+}
+
+func newParquetResultWriter(outputFile string, valueNames []string, long bool,
+	crs bool) (*parquetResultWriter, error) {
+	schema := []string{
+		"name=tile_id, type=BYTE_ARRAY, convertedtype=UTF8",
+		"name=date, type=INT32, convertedtype=DATE",
+		"name=acquired, type=INT64, convertedtype=TIMESTAMP_MILLIS",
+	}
+	if !long {
+		schema = append(schema,
+			"name=min_lon, type=DOUBLE",
+			"name=min_lat, type=DOUBLE",
+			"name=max_lon, type=DOUBLE",
+			"name=max_lat, type=DOUBLE",
+		)
+	}
+	if crs {
+		schema = append(schema, "name=crs, type=BYTE_ARRAY, convertedtype=UTF8")
+	}
+	if long {
+		schema = append(schema,
+			"name=variable, type=BYTE_ARRAY, convertedtype=UTF8",
+			"name=value, type=DOUBLE",
+		)
+	} else {
+		for _, name := range valueNames {
+			schema = append(schema, "name="+parquetColumnName(name)+", type=DOUBLE")
+		}
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create parquet file")
+	}
+	pw, err := writer.NewCSVWriterFromWriter(schema, file, parquetParallelism)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to create parquet writer")
+	}
+	w := &parquetResultWriter{file: file, writer: pw, crs: crs}
+	if long {
+		w.longNames = valueNames
+	}
+	return w, nil
+}
+
+func (w *parquetResultWriter) write(result analytics.TileValues) error {
+	row := []interface{}{result.Tile.GeoHash, dateDays(result.Tile), result.Tile.Timestamp * 1000}
+	if w.longNames == nil {
+		bounds := result.Bounds
+		row = append(row, bounds.MinLon, bounds.MinLat, bounds.MaxLon, bounds.MaxLat)
+	}
+	if w.crs {
+		row = append(row, result.CRS)
+	}
+	if w.longNames != nil {
+		for i, value := range result.Values {
+			if err := w.writer.Write(append(row[:len(row):len(row)], w.longNames[i], value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, value := range result.Values {
+		row = append(row, value)
+	}
+	return w.writer.Write(row)
+}
+
+func (w *parquetResultWriter) close() error {
+	if err := w.writer.WriteStop(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "failed to write parquet file")
+	}
+	return w.file.Close()
+}
+
+// Returns the tile date as the number of days since the unix epoch, matching the day written by formatDate.
+func dateDays(tile analytics.Tile) int32 {
+	year, month, day := time.Unix(tile.Timestamp, 0).UTC().Date()
+	return int32(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+}
+
+// Replaces characters that can't be used in a parquet column name with underscores.
+func parquetColumnName(name string) string {
+	return parquetInvalidChars.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// Writes the results to a csv file, closing the writer.
+func writeCSVResults(t *testing.T, outputFile string, valueNames []string, long bool, previous string,
+	results ...analytics.TileValues) {
+	w, err := newCSVResultWriter(outputFile, valueNames, long, false, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reads the rows of a csv file, joining the fields of each row with a |.
+func readCSVRows(t *testing.T, filePath string) []string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]string, len(records))
+	for i, record := range records {
+		rows[i] = strings.Join(record, "|")
+	}
+	return rows
+}
+
+// parquetWideRow is a row of the wide parquet layout with a single mean_ndvi value.
+type parquetWideRow struct {
+	TileID   string  `parquet:"name=tile_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date     int32   `parquet:"name=date, type=INT32, convertedtype=DATE"`
+	Acquired int64   `parquet:"name=acquired, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	MinLon   float64 `parquet:"name=min_lon, type=DOUBLE"`
+	MinLat   float64 `parquet:"name=min_lat, type=DOUBLE"`
+	MaxLon   float64 `parquet:"name=max_lon, type=DOUBLE"`
+	MaxLat   float64 `parquet:"name=max_lat, type=DOUBLE"`
+	CRS      string  `parquet:"name=crs, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MeanNDVI float64 `parquet:"name=mean_ndvi, type=DOUBLE"`
+}
+
+// parquetLongRow is a row of the long parquet layout.
+type parquetLongRow struct {
+	TileID   string  `parquet:"name=tile_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Date     int32   `parquet:"name=date, type=INT32, convertedtype=DATE"`
+	Acquired int64   `parquet:"name=acquired, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	CRS      string  `parquet:"name=crs, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Variable string  `parquet:"name=variable, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value    float64 `parquet:"name=value, type=DOUBLE"`
+}
+
+// Writes the results to a parquet file, closing the writer.
+func writeParquetResults(t *testing.T, outputFile string, valueNames []string, long bool,
+	results ...analytics.TileValues) {
+	w, err := newParquetResultWriter(outputFile, valueNames, long, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reads the rows of a parquet file into rows, which points to a slice of the row type.
+func readParquetRows(t *testing.T, filePath string, row interface{}, rows interface{}) {
+	file, err := local.NewLocalFileReader(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pr, err := reader.NewParquetReader(file, row, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if err := pr.Read(rows); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkRows(t *testing.T, name string, rows []string, want []string) {
+	if len(rows) != len(want) {
+		t.Errorf("%s: %d rows, want %d", name, len(rows), len(want))
+		return
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("%s: row %d is %s, want %s", name, i, rows[i], want[i])
+		}
+	}
+}
+
+func TestCSVResultWriterPrevious(t *testing.T) {
+	bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+	result := func(geoHash string, value float64) analytics.TileValues {
+		return analytics.TileValues{Tile: analytics.Tile{GeoHash: geoHash, Timestamp: 1577874600}, Bounds: bounds,
+			Values: []float64{value}}
+	}
+	row := func(geoHash string, value string) string {
+		return geoHash + "|2020-01-01|2020-01-01T10:30:00.000Z|" + bounds.String() + "|" + value
+	}
+	header := "tile_id|date|acquired|bounds|mean_ndvi"
+
+	previous := writeTempFile(t, "")
+	defer os.Remove(previous)
+	writeCSVResults(t, previous, []string{"mean_ndvi"}, false, "", result("a", 0.25), result("b", 0.5))
+
+	// the rows of the previous output are copied ahead of the new rows
+	outputFile := writeTempFile(t, "")
+	defer os.Remove(outputFile)
+	writeCSVResults(t, outputFile, []string{"mean_ndvi"}, false, previous, result("c", 0.75))
+	checkRows(t, "copied", readCSVRows(t, outputFile), []string{header, row("a", "0.25"), row("b", "0.5"),
+		row("c", "0.75")})
+
+	// when the previous output is the output file the new rows are appended to it
+	writeCSVResults(t, previous, []string{"mean_ndvi"}, false, previous, result("c", 0.75))
+	checkRows(t, "appended", readCSVRows(t, previous), []string{header, row("a", "0.25"), row("b", "0.5"),
+		row("c", "0.75")})
+
+	if _, err := newCSVResultWriter(outputFile, []string{"mean_ndvi"}, false, false, previous+"_missing"); err == nil {
+		t.Error("expected an error for a missing previous output")
+	}
+}
+
+func TestParquetResultWriter(t *testing.T) {
+	outputFile := writeTempFile(t, "")
+	defer os.Remove(outputFile)
+	bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+	writeParquetResults(t, outputFile, []string{"mean-ndvi"}, false,
+		analytics.TileValues{Tile: analytics.Tile{GeoHash: "9q8yy", Timestamp: 1577874600}, Bounds: bounds,
+			CRS: "EPSG:32610", Values: []float64{0.25}},
+		analytics.TileValues{Tile: analytics.Tile{GeoHash: "9q8yz", Timestamp: 1577923199}, Bounds: bounds,
+			CRS: "EPSG:32610", Values: []float64{0.5}})
+
+	// the value name is written with the invalid characters replaced
+	rows := make([]parquetWideRow, 2)
+	readParquetRows(t, outputFile, new(parquetWideRow), &rows)
+	want := []parquetWideRow{
+		{"9q8yy", 18262, 1577874600000, -122.5, 37.5, -122, 38, "EPSG:32610", 0.25},
+		{"9q8yz", 18262, 1577923199000, -122.5, 37.5, -122, 38, "EPSG:32610", 0.5},
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d is %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestLongLayout(t *testing.T) {
+	outputFile := writeTempFile(t, "")
+	defer os.Remove(outputFile)
+	bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+	results := []analytics.TileValues{
+		{Tile: analytics.Tile{GeoHash: "9q8yy", Timestamp: 1577874600}, Bounds: bounds, CRS: "EPSG:32610",
+			Values: []float64{0.25, 0.1}},
+		{Tile: analytics.Tile{GeoHash: "9q8yz", Timestamp: 1577923199}, Bounds: bounds, CRS: "EPSG:32610",
+			Values: []float64{0.5, 0.2}},
+	}
+	valueNames := []string{"mean_ndvi", "mean_ndwi"}
+
+	// each value is expanded to a row keyed by the tile id, date and acquisition time
+	writeCSVResults(t, outputFile, valueNames, true, "", results...)
+	checkRows(t, "csv", readCSVRows(t, outputFile), []string{
+		"tile_id|date|acquired|variable|value",
+		"9q8yy|2020-01-01|2020-01-01T10:30:00.000Z|mean_ndvi|0.25",
+		"9q8yy|2020-01-01|2020-01-01T10:30:00.000Z|mean_ndwi|0.1",
+		"9q8yz|2020-01-01|2020-01-01T23:59:59.000Z|mean_ndvi|0.5",
+		"9q8yz|2020-01-01|2020-01-01T23:59:59.000Z|mean_ndwi|0.2",
+	})
+
+	writeParquetResults(t, outputFile, valueNames, true, results...)
+	rows := make([]parquetLongRow, 4)
+	readParquetRows(t, outputFile, new(parquetLongRow), &rows)
+	want := []parquetLongRow{
+		{"9q8yy", 18262, 1577874600000, "EPSG:32610", "mean_ndvi", 0.25},
+		{"9q8yy", 18262, 1577874600000, "EPSG:32610", "mean_ndwi", 0.1},
+		{"9q8yz", 18262, 1577923199000, "EPSG:32610", "mean_ndvi", 0.5},
+		{"9q8yz", 18262, 1577923199000, "EPSG:32610", "mean_ndwi", 0.2},
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("parquet: row %d is %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+	log "github.com/unchartedsoftware/plog"
+)
+
+const (
+	// maximum number of jobs in flight per worker
+	jobsPerWorker = 16
+)
+
+// job is a unit of work for the tile workers - either a single tile, or the date sorted series of
+// tiles for a geohash when a temporal analytic is applied.
+type job struct {
+	seq   int
+	tiles []analytics.Tile
+}
+
+// jobResult holds the values generated for each tile of a job.
+type jobResult struct {
+	seq    int
+	values []analytics.TileValues
+}
+
+// errorPolicy determines how many failed tiles are tolerated before processing is aborted.
+type errorPolicy struct {
+	// maximum number of failed tiles, or 0 to skip failed tiles without limit
+	maxFailures int
+}
+
+// Parses an error policy of the form skip, fail, or fail-after=N.
+func parseErrorPolicy(policy string) (errorPolicy, error) {
+	switch {
+	case policy == "skip":
+		return errorPolicy{}, nil
+	case policy == "fail":
+		return errorPolicy{maxFailures: 1}, nil
+	case strings.HasPrefix(policy, "fail-after="):
+		maxFailures, err := strconv.Atoi(strings.TrimPrefix(policy, "fail-after="))
+		if err != nil || maxFailures < 1 {
+			return errorPolicy{}, errors.Errorf("invalid failure count in %s", policy)
+		}
+		return errorPolicy{maxFailures: maxFailures}, nil
+	default:
+		return errorPolicy{}, errors.Errorf("unrecognized error policy %s", policy)
+	}
+}
+
+// failureTracker aggregates the failed tiles reported by the workers, and signals them to stop once
+// the error policy has been exceeded.
+type failureTracker struct {
+	policy  errorPolicy
+	mutex   sync.Mutex
+	count   int
+	aborted bool
+	abort   chan struct{}
+}
+
+func newFailureTracker(policy errorPolicy) *failureTracker {
+	return &failureTracker{policy: policy, abort: make(chan struct{})}
+}
+
+// Adds the failed tiles of a job result to the total.
+func (f *failureTracker) add(result jobResult) {
+	failures := 0
+	for _, values := range result.values {
+		if values.Err != nil {
+			failures++
+		}
+	}
+	if failures == 0 {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.count += failures
+	if f.policy.maxFailures > 0 && f.count >= f.policy.maxFailures && !f.aborted {
+		f.aborted = true
+		close(f.abort)
+	}
+}
+
+// Returns true if processing has been aborted.
+func (f *failureTracker) isAborted() bool {
+	select {
+	case <-f.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// pipelineOptions controls how tiles are processed.
+type pipelineOptions struct {
+	workers int
+	// pass results to the handler in geohash, date order
+	ordered bool
+	policy  errorPolicy
+	// keys of the tiles that have already been processed, which are skipped
+	completed map[string]bool
+	// how the band files of each tile are read
+	input analytics.InputOptions
+}
+
+// apply analytic operations to tiles and pass the results to the handler as they are completed.  If
+// ordered is set, results are passed in geohash, date order.  The number of jobs in flight is bounded,
+// so results are streamed through rather than collected in memory.  An error is returned if processing
+// is aborted by the error policy.
+func processTiles(inputDir string, tileAnalytics []analytics.Analytic, options pipelineOptions,
+	handler func(analytics.TileValues)) error {
+	workers := options.workers
+	// Scan the input dir and collect tile information by parsing each file name
+	tileMap, err := createTileMap(inputDir, options.input)
+	if err != nil {
+		return errors.Wrap(err, "failed to read tile information")
+	}
+	process, temporal := newTileProcessor(inputDir, tileAnalytics)
+
+	// Limit the number of jobs in flight.  When results are ordered this also bounds the number of
+	// results held in the reorder buffer while waiting for a slow job.
+	window := make(chan struct{}, workers*jobsPerWorker)
+	results := make(chan jobResult, workers)
+	failures := newFailureTracker(options.policy)
+	queue := &jobQueue{jobs: make(chan job, workers), window: window, abort: failures.abort}
+
+	// Send all of the tiles to the workers, stopping early if processing is aborted
+	go queue.sendTiles(tileMap, temporal, options.completed)
+
+	// Start workers.  Mileage will vary given that IO is the bottleneck, and HDD
+	// reads don't parallelize.  SSD will allow for parallel reads, and you should
+	// get some OS level cacheing in either case if the tile data has been loaded recently.
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go tileWorker(i, queue.jobs, results, &wg, process, failures)
+	}
+
+	// Wait for workers to finish
+	go func() {
+		defer close(results)
+		wg.Wait()
+	}()
+
+	emitter := &resultEmitter{handler: handler, window: window, ordered: options.ordered, pending: map[int]jobResult{}}
+	for result := range results {
+		emitter.add(result)
+	}
+	emitter.flush()
+
+	if failures.isAborted() {
+		return errors.Errorf("aborted after %d failed tiles", failures.count)
+	}
+	return nil
+}
+
+// Returns the function generating the values of a job's tiles, and whether the analytics are temporal.
+// Temporal analytics are applied to the date sorted tiles for each geohash, while per-tile analytics are
+// applied together to each tile so that they can share loaded images.
+func newTileProcessor(inputDir string,
+	tileAnalytics []analytics.Analytic) (func(tiles []analytics.Tile) []analytics.TileValues, bool) {
+	if temporalAnalytic, ok := tileAnalytics[0].(analytics.TemporalTransformer); ok {
+		return func(tiles []analytics.Tile) []analytics.TileValues {
+			return temporalAnalytic.TransformSeries(inputDir, tiles)
+		}, true
+	}
+
+	transformers := []analytics.Transformer{}
+	for _, tileAnalytic := range tileAnalytics {
+		if transformer, ok := tileAnalytic.(analytics.Transformer); ok {
+			transformers = append(transformers, transformer)
+		}
+	}
+	return func(tiles []analytics.Tile) []analytics.TileValues {
+		return []analytics.TileValues{analytics.Apply(inputDir, tiles[0], transformers...)}
+	}, false
+}
+
+// jobQueue sends jobs to the workers, waiting for a slot in the window of jobs in flight.
+type jobQueue struct {
+	jobs   chan job
+	window chan struct{}
+	abort  <-chan struct{}
+	seq    int
+}
+
+// Sends a job for each tile, or each geohash if temporal is set, in geohash order.  Completed tiles are
+// skipped.  The jobs channel is closed once the tiles are sent or processing is aborted.
+func (q *jobQueue) sendTiles(tileMap map[string][]analytics.Tile, temporal bool, completed map[string]bool) {
+	defer close(q.jobs)
+	for _, geoHash := range sortedGeoHashes(tileMap) {
+		tiles := tileMap[geoHash]
+		if temporal {
+			if !q.send(tiles) {
+				return
+			}
+			continue
+		}
+		for _, tile := range tiles {
+			if completed[tileKey(tile.GeoHash, formatAcquired(tile))] {
+				continue
+			}
+			if !q.send([]analytics.Tile{tile}) {
+				return
+			}
+		}
+	}
+}
+
+// Sends a job, returning false if processing was aborted first.
+func (q *jobQueue) send(tiles []analytics.Tile) bool {
+	select {
+	case q.window <- struct{}{}:
+	case <-q.abort:
+		return false
+	}
+	select {
+	case q.jobs <- job{seq: q.seq, tiles: tiles}:
+	case <-q.abort:
+		return false
+	}
+	q.seq++
+	return true
+}
+
+// resultEmitter hands off results to the handler, releasing their slot in the window.  When ordered is
+// set, results that complete ahead of their turn are held until the preceding jobs are done.
+type resultEmitter struct {
+	handler func(analytics.TileValues)
+	window  chan struct{}
+	ordered bool
+	pending map[int]jobResult
+	next    int
+}
+
+// Emits a result, along with any pending results that follow it, or holds it until its turn.
+func (e *resultEmitter) add(result jobResult) {
+	if !e.ordered {
+		e.emit(result)
+		return
+	}
+	e.pending[result.seq] = result
+	for {
+		nextResult, ok := e.pending[e.next]
+		if !ok {
+			return
+		}
+		delete(e.pending, e.next)
+		e.emit(nextResult)
+		e.next++
+	}
+}
+
+// Emits the pending results.  If processing was aborted, jobs were discarded and the remaining results
+// won't become contiguous.  They are passed on in order so that their failures are still reported.
+func (e *resultEmitter) flush() {
+	remaining := make([]int, 0, len(e.pending))
+	for seq := range e.pending {
+		remaining = append(remaining, seq)
+	}
+	sort.Ints(remaining)
+	for _, seq := range remaining {
+		e.emit(e.pending[seq])
+		delete(e.pending, seq)
+	}
+}
+
+func (e *resultEmitter) emit(result jobResult) {
+	for _, values := range result.values {
+		e.handler(values)
+	}
+	<-e.window
+}
+
+// Processes a tile batch, reporting failed tiles to the failure tracker.  Remaining jobs are discarded
+// once processing has been aborted.
+func tileWorker(worker int, jobs <-chan job, results chan<- jobResult, wg *sync.WaitGroup,
+	process func(tiles []analytics.Tile) []analytics.TileValues, failures *failureTracker) {
+	defer wg.Done()
+
+	count := 0
+	for j := range jobs {
+		if failures.isAborted() {
+			continue
+		}
+		result := jobResult{seq: j.seq, values: process(j.tiles)}
+		failures.add(result)
+		results <- result
+
+		count++
+		if count%100 == 0 {
+			log.Infof("worker %d: processed %d", worker, count)
+		}
+	}
+
+	log.Infof("worker %d: tile processing complete", worker)
+}
+
+// Returns the geohashes of the tile map in sorted order.
+func sortedGeoHashes(tileMap map[string][]analytics.Tile) []string {
+	geoHashes := make([]string, 0, len(tileMap))
+	for geoHash := range tileMap {
+		geoHashes = append(geoHashes, geoHash)
+	}
+	sort.Strings(geoHashes)
+	return geoHashes
+}
+
+// errorSummary tracks the tile errors encountered while processing.
+type errorSummary struct {
+	setupErrCount int
+	lastSetupErr  error
+
+	transformErrCount int
+	lastTransformErr  error
+}
+
+func (s *errorSummary) add(err error) {
+	if tileErr, ok := err.(*analytics.TileError); ok && tileErr.Stage == analytics.StageTransform {
+		s.transformErrCount++
+		s.lastTransformErr = tileErr.Err
+		return
+	}
+	if tileErr, ok := err.(*analytics.TileError); ok {
+		err = tileErr.Err
+	}
+	s.setupErrCount++
+	s.lastSetupErr = err
+}
+
+func (s *errorSummary) log() {
+	if s.setupErrCount > 0 {
+		log.Warnf("encountered %d setup errors", s.setupErrCount)
+		log.Warnf("last setup error: %s", s.lastSetupErr)
+	}
+
+	if s.transformErrCount > 0 {
+		log.Warnf("encountered %d transform errors", s.transformErrCount)
+		log.Warnf("last transform error: %s", s.lastTransformErr)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// fakeAnalytic generates the tile timestamp as its value without reading the band files.  Tiles of the
+// failing geohashes fail setup, and tiles are delayed to complete out of order.
+type fakeAnalytic struct {
+	failing map[string]bool
+}
+
+func (f fakeAnalytic) Setup(inputDir string, tile *analytics.Tile) ([]*analytics.GeoImage, error) {
+	if f.failing[tile.GeoHash] {
+		return nil, fmt.Errorf("failed to load %s", tile.GeoHash)
+	}
+	// vary the processing time so that tiles complete out of order
+	time.Sleep(time.Duration(tile.Timestamp%7) * time.Millisecond)
+	return []*analytics.GeoImage{{Data: []float64{float64(tile.Timestamp)}, XSize: 1, YSize: 1}}, nil
+}
+
+func (f fakeAnalytic) Transform(tileData []*analytics.GeoImage) ([]float64, error) {
+	return []float64{tileData[0].Data[0]}, nil
+}
+
+func (f fakeAnalytic) ValueNames() []string {
+	return []string{"timestamp"}
+}
+
+// Creates an input directory with a band file for each of the geohashes on each of the days.
+func createTestTiles(t *testing.T, geoHashes []string, days int) string {
+	inputDir, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, geoHash := range geoHashes {
+		for day := 1; day <= days; day++ {
+			name := fmt.Sprintf("%s_202001%02dT103000_B04.tif", geoHash, day)
+			if err := ioutil.WriteFile(path.Join(inputDir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return inputDir
+}
+
+// Runs the pipeline, returning the results in the order they were passed to the handler.
+func runPipeline(t *testing.T, inputDir string, analytic analytics.Analytic,
+	options pipelineOptions) ([]analytics.TileValues, error) {
+	var mutex sync.Mutex
+	results := []analytics.TileValues{}
+	err := processTiles(inputDir, []analytics.Analytic{analytic}, options, func(result analytics.TileValues) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+	})
+	return results, err
+}
+
+func TestProcessTilesOrdered(t *testing.T) {
+	inputDir := createTestTiles(t, []string{"c", "a", "b"}, 10)
+	defer os.RemoveAll(inputDir)
+
+	results, err := runPipeline(t, inputDir, fakeAnalytic{}, pipelineOptions{workers: 4, ordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 30 {
+		t.Fatalf("%d results, want 30", len(results))
+	}
+	for i, result := range results {
+		geoHash := string(rune('a' + i/10))
+		date := fmt.Sprintf("202001%02dT103000", i%10+1)
+		if result.Tile.GeoHash != geoHash || result.Tile.Date != date {
+			t.Fatalf("result %d is %s_%s, want %s_%s", i, result.Tile.GeoHash, result.Tile.Date, geoHash, date)
+		}
+		if result.Err != nil || result.Values[0] != float64(result.Tile.Timestamp) {
+			t.Errorf("result %d has values %v and error %v", i, result.Values, result.Err)
+		}
+	}
+}
+
+func TestProcessTilesUnordered(t *testing.T) {
+	inputDir := createTestTiles(t, []string{"a", "b"}, 10)
+	defer os.RemoveAll(inputDir)
+
+	results, err := runPipeline(t, inputDir, fakeAnalytic{}, pipelineOptions{workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, result := range results {
+		seen[result.Tile.GeoHash+result.Tile.Date] = true
+	}
+	if len(results) != 20 || len(seen) != 20 {
+		t.Errorf("%d results for %d tiles, want 20", len(results), len(seen))
+	}
+}
+
+func TestProcessTilesCompleted(t *testing.T) {
+	inputDir := createTestTiles(t, []string{"a", "b"}, 3)
+	defer os.RemoveAll(inputDir)
+
+	completed := map[string]bool{
+		tileKey("a", "2020-01-02T10:30:00.000Z"): true,
+		tileKey("b", "2020-01-01T10:30:00.000Z"): true,
+		tileKey("b", "2020-01-03T10:30:00.000Z"): true,
+	}
+	results, err := runPipeline(t, inputDir, fakeAnalytic{},
+		pipelineOptions{workers: 2, ordered: true, completed: completed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, result := range results {
+		got = append(got, result.Tile.GeoHash+"_"+result.Tile.Date)
+	}
+	want := []string{"a_20200101T103000", "a_20200103T103000", "b_20200102T103000"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("processed %v, want %v", got, want)
+	}
+}
+
+func TestProcessTilesSkipFailures(t *testing.T) {
+	inputDir := createTestTiles(t, []string{"a", "b", "c"}, 5)
+	defer os.RemoveAll(inputDir)
+
+	analytic := fakeAnalytic{failing: map[string]bool{"b": true}}
+	results, err := runPipeline(t, inputDir, analytic, pipelineOptions{workers: 3, ordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 15 {
+		t.Fatalf("%d results, want 15", len(results))
+	}
+	for _, result := range results {
+		if (result.Err != nil) != (result.Tile.GeoHash == "b") {
+			t.Errorf("%s_%s has error %v", result.Tile.GeoHash, result.Tile.Date, result.Err)
+		}
+	}
+}
+
+func TestProcessTilesAbort(t *testing.T) {
+	inputDir := createTestTiles(t, []string{"a", "b", "c", "d"}, 50)
+	defer os.RemoveAll(inputDir)
+
+	for _, ordered := range []bool{false, true} {
+		analytic := fakeAnalytic{failing: map[string]bool{"a": true}}
+		options := pipelineOptions{workers: 4, ordered: ordered, policy: errorPolicy{maxFailures: 3}}
+		results, err := runPipeline(t, inputDir, analytic, options)
+		if err == nil {
+			t.Errorf("ordered %v: expected processing to be aborted", ordered)
+		}
+
+		failures := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failures++
+			}
+		}
+		if failures < 3 {
+			t.Errorf("ordered %v: %d failures reported, want at least 3", ordered, failures)
+		}
+		if len(results) >= 200 {
+			t.Errorf("ordered %v: all %d tiles were processed", ordered, len(results))
+		}
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		maxFailures int
+		fails       bool
+	}{
+		{"skip", 0, false},
+		{"fail", 1, false},
+		{"fail-after=5", 5, false},
+		{"fail-after=1", 1, false},
+		{"fail-after=0", 0, true},
+		{"fail-after=-2", 0, true},
+		{"fail-after=x", 0, true},
+		{"fail-after=", 0, true},
+		{"abort", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		policy, err := parseErrorPolicy(test.policy)
+		if (err != nil) != test.fails {
+			t.Errorf("%q: unexpected error %v", test.policy, err)
+			continue
+		}
+		if policy.maxFailures != test.maxFailures {
+			t.Errorf("%q: max failures %d, want %d", test.policy, policy.maxFailures, test.maxFailures)
+		}
+	}
+}
+
+func TestResultEmitter(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+		seqs    []int
+		// results emitted as they are added, before flushing
+		emitted []int
+		want    []int
+	}{
+		{"unordered", false, []int{2, 0, 1}, []int{2, 0, 1}, []int{2, 0, 1}},
+		{"ordered", true, []int{2, 0, 1}, []int{0, 1, 2}, []int{0, 1, 2}},
+		{"ordered with a gap", true, []int{3, 0, 5, 1}, []int{0, 1}, []int{0, 1, 3, 5}},
+	}
+	for _, test := range tests {
+		window := make(chan struct{}, len(test.seqs))
+		got := []int{}
+		emitter := &resultEmitter{
+			window:  window,
+			ordered: test.ordered,
+			pending: map[int]jobResult{},
+			handler: func(values analytics.TileValues) {
+				got = append(got, int(values.Tile.Timestamp))
+			},
+		}
+		for _, seq := range test.seqs {
+			window <- struct{}{}
+			emitter.add(jobResult{seq: seq, values: []analytics.TileValues{{Tile: analytics.Tile{Timestamp: int64(seq)}}}})
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.emitted) {
+			t.Errorf("%s: emitted %v before flushing, want %v", test.name, got, test.emitted)
+		}
+		emitter.flush()
+		if fmt.Sprint(got) != fmt.Sprint(test.want) || len(window) != 0 {
+			t.Errorf("%s: emitted %v with %d slots held, want %v", test.name, got, len(window), test.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// size of the chunks read when searching for the end of the last complete row
+const resumeChunkSize = 64 * 1024
+
+// Returns the key identifying a tile in the output - its geohash and formatted acquisition time.
+func tileKey(geoHash string, acquired string) string {
+	return geoHash + "_" + acquired
+}
+
+// Removes any partially written row from the output of an interrupted run, returning the output file as
+// the previous output to resume from.  An empty string is returned if the output doesn't exist or is empty
+// (ie. the run was interrupted before its header was written), in which case the run starts afresh.
+func resumeOutput(outputFile string) (string, error) {
+	if _, err := os.Stat(outputFile); os.IsNotExist(err) {
+		return "", nil
+	}
+	err := truncatePartialRow(outputFile)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(outputFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", outputFile)
+	}
+	if info.Size() == 0 {
+		return "", nil
+	}
+	return outputFile, nil
+}
+
+// Reads the keys of the tiles already written to an output file so that a run can be resumed or
+// extended.  An error is returned if the file was written with a different header.
+func readCompletedTiles(outputFile string, header []string) (map[string]bool, error) {
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", outputFile)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	existingHeader, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.Errorf("%s has no header", outputFile)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read header of %s", outputFile)
+	}
+	if !equalStrings(existingHeader, header) {
+		return nil, errors.Errorf("%s was written with different operations", outputFile)
+	}
+
+	completed := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", outputFile)
+		}
+		// the acquisition time follows the tile id and date in both layouts
+		completed[tileKey(record[0], record[2])] = true
+	}
+	return completed, nil
+}
+
+// Copies the rows of a previous output file, excluding its header, to the writer.
+func copyRows(previousFile string, writer *csv.Writer) error {
+	file, err := os.Open(previousFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", previousFile)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	if _, err := reader.Read(); err != nil {
+		return errors.Wrapf(err, "failed to read header of %s", previousFile)
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", previousFile)
+		}
+		if err := writer.Write(record); err != nil {
+			return errors.Wrap(err, "failed to copy previous rows")
+		}
+	}
+}
+
+// Returns true if both paths refer to the same existing file.
+func sameFile(a string, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// Truncates a file after its last newline, removing any partially written row.
+func truncatePartialRow(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", filePath)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", filePath)
+	}
+
+	// scan backwards through the file for the last newline
+	buffer := make([]byte, resumeChunkSize)
+	end := info.Size()
+	for end > 0 {
+		start := end - resumeChunkSize
+		if start < 0 {
+			start = 0
+		}
+		chunk := buffer[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return errors.Wrapf(err, "failed to read %s", filePath)
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] == '\n' {
+				return truncateTo(file, info.Size(), start+int64(i)+1)
+			}
+		}
+		end = start
+	}
+	return truncateTo(file, info.Size(), 0)
+}
+
+func truncateTo(file *os.File, size int64, length int64) error {
+	if length == size {
+		return nil
+	}
+	return errors.Wrapf(file.Truncate(length), "failed to truncate %s", file.Name())
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
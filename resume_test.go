@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+// Writes the contents to a temporary file, returning its path.
+func writeTempFile(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestTruncatePartialRow(t *testing.T) {
+	longRow := strings.Repeat("x", 3*resumeChunkSize)
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"empty", "", ""},
+		{"complete", "a,b\n1,2\n", "a,b\n1,2\n"},
+		{"partial row", "a,b\n1,2\n3,", "a,b\n1,2\n"},
+		{"partial header", "a,", ""},
+		{"partial row longer than a chunk", "a,b\n" + longRow, "a,b\n"},
+		{"row ending on a chunk boundary", longRow[:resumeChunkSize-1] + "\n" + longRow,
+			longRow[:resumeChunkSize-1] + "\n"},
+		{"complete rows longer than a chunk", "a,b\n" + longRow + "\n", "a,b\n" + longRow + "\n"},
+	}
+
+	for _, test := range tests {
+		filePath := writeTempFile(t, test.contents)
+		if err := truncatePartialRow(filePath); err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		got, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: truncated to %d bytes, want %d", test.name, len(got), len(test.want))
+		}
+		os.Remove(filePath)
+	}
+}
+
+func TestResumeOutput(t *testing.T) {
+	header := strings.Join(csvHeader([]string{"mean_ndvi"}, layoutWide, false), ",") + "\n"
+	tests := []struct {
+		name     string
+		contents string
+		resumed  bool
+	}{
+		{"empty", "", false},
+		{"partial header", "tile_id,da", false},
+		{"header only", header, true},
+		{"partial row", header + "a,2020-01-01,", true},
+	}
+	for _, test := range tests {
+		filePath := writeTempFile(t, test.contents)
+		previous, err := resumeOutput(filePath)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if (previous != "") != test.resumed {
+			t.Errorf("%s: previous output %q", test.name, previous)
+		}
+		if previous != "" {
+			if _, err := readCompletedTiles(previous, csvHeader([]string{"mean_ndvi"}, layoutWide, false)); err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+		}
+		os.Remove(filePath)
+	}
+
+	missing := writeTempFile(t, "")
+	os.Remove(missing)
+	if previous, err := resumeOutput(missing); err != nil || previous != "" {
+		t.Errorf("missing output resumed as %q with error %v", previous, err)
+	}
+}
+
+func TestReadCompletedTiles(t *testing.T) {
+	header := csvHeader([]string{"mean_ndvi"}, layoutWide, false)
+	filePath := writeTempFile(t, strings.Join(header, ",")+"\n"+
+		"a,2020-01-01,2020-01-01T00:00:00.000Z,\"1,2,3,4\",0.5\n"+
+		"a,2020-01-01,2020-01-01T10:30:00.000Z,\"1,2,3,4\",0.6\n"+
+		"b,2020-01-02,2020-01-02T10:30:00.000Z,\"1,2,3,4\",0.7\n")
+	defer os.Remove(filePath)
+
+	completed, err := readCompletedTiles(filePath, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a_2020-01-01T00:00:00.000Z", "a_2020-01-01T10:30:00.000Z",
+		"b_2020-01-02T10:30:00.000Z"} {
+		if !completed[key] {
+			t.Errorf("%s is not completed", key)
+		}
+	}
+	if len(completed) != 3 {
+		t.Errorf("%d completed tiles, want 3", len(completed))
+	}
+
+	if _, err := readCompletedTiles(filePath, csvHeader([]string{"mean_ndwi"}, layoutWide, false)); err == nil {
+		t.Error("expected an error for a different header")
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	tests := []struct {
+		timestamp int64
+		date      string
+		acquired  string
+	}{
+		{1577836800, "2020-01-01", "2020-01-01T00:00:00.000Z"},
+		{1577874600, "2020-01-01", "2020-01-01T10:30:00.000Z"},
+		{1577923199, "2020-01-01", "2020-01-01T23:59:59.000Z"},
+	}
+	for _, test := range tests {
+		tile := analytics.Tile{Timestamp: test.timestamp}
+		if got := formatDate(tile); got != test.date {
+			t.Errorf("formatDate(%d) = %s, want %s", test.timestamp, got, test.date)
+		}
+		if got := formatAcquired(tile); got != test.acquired {
+			t.Errorf("formatAcquired(%d) = %s, want %s", test.timestamp, got, test.acquired)
+		}
+	}
+}
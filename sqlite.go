@@ -0,0 +1,331 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	// register the sqlite3 database driver
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+const (
+	// name of the table or geopackage layer that results are written to
+	sqliteTableName = "tiles"
+
+	// number of rows written per transaction
+	sqliteBatchSize = 1000
+
+	// geopackage constants (see http://www.geopackage.org/spec120/)
+	gpkgApplicationID = 0x47504B47
+	gpkgUserVersion   = 10200
+	gpkgGeometryName  = "geom"
+	// little endian byte order with an xy envelope
+	gpkgGeometryFlags = 0x03
+
+	wkbPolygon = 3
+
+	wgs84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,` +
+		`AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],` +
+		`UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`
+)
+
+// Statements creating the geopackage metadata tables and their required rows.
+var gpkgSchema = []string{
+	`CREATE TABLE IF NOT EXISTS gpkg_spatial_ref_sys (
+		srs_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL PRIMARY KEY,
+		organization TEXT NOT NULL,
+		organization_coordsys_id INTEGER NOT NULL,
+		definition TEXT NOT NULL,
+		description TEXT)`,
+	`CREATE TABLE IF NOT EXISTS gpkg_contents (
+		table_name TEXT NOT NULL PRIMARY KEY,
+		data_type TEXT NOT NULL,
+		identifier TEXT UNIQUE,
+		description TEXT DEFAULT '',
+		last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+		min_x DOUBLE,
+		min_y DOUBLE,
+		max_x DOUBLE,
+		max_y DOUBLE,
+		srs_id INTEGER,
+		CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id))`,
+	`CREATE TABLE IF NOT EXISTS gpkg_geometry_columns (
+		table_name TEXT NOT NULL,
+		column_name TEXT NOT NULL,
+		geometry_type_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL,
+		z TINYINT NOT NULL,
+		m TINYINT NOT NULL,
+		CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+		CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+		CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+		CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id))`,
+	`INSERT OR IGNORE INTO gpkg_spatial_ref_sys VALUES
+		('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+		('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+		('WGS 84 geodetic', 4326, 'EPSG', 4326, '` + wgs84WKT + `', 'longitude/latitude coordinates in decimal degrees')`,
+}
+
+// sqliteResultWriter upserts a row for each tile into a SQLite database table, or a GeoPackage layer
+// with the tile polygon as its geometry.  Rows are keyed by geohash and acquisition time, so repeated runs
+// update existing rows in place.  Value columns missing from an existing table are added.
+type sqliteResultWriter struct {
+	db         *sql.DB
+	tx         *sql.Tx
+	insert     *sql.Stmt
+	geoPackage bool
+	crs        bool
+	pending    int
+	// extent of the rows written to the geopackage layer
+	extent *analytics.GeoBounds
+}
+
+func newSQLiteResultWriter(outputFile string, valueNames []string, geoPackage bool,
+	crs bool) (*sqliteResultWriter, error) {
+	db, err := sql.Open("sqlite3", outputFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", outputFile)
+	}
+	db.SetMaxOpenConns(1)
+	w := &sqliteResultWriter{db: db, geoPackage: geoPackage, crs: crs}
+
+	err = w.createTable(valueNames)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	w.insert, err = db.Prepare(w.upsertSQL(valueNames))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to prepare insert")
+	}
+	w.tx, err = db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	return w, nil
+}
+
+// Creates the results table if it doesn't exist, along with the geopackage metadata, and adds the crs
+// and value columns that are missing.
+func (w *sqliteResultWriter) createTable(valueNames []string) error {
+	statements := []string{}
+	if w.geoPackage {
+		statements = append(statements,
+			fmt.Sprintf("PRAGMA application_id = %d", gpkgApplicationID),
+			fmt.Sprintf("PRAGMA user_version = %d", gpkgUserVersion),
+		)
+		statements = append(statements, gpkgSchema...)
+		statements = append(statements,
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				fid INTEGER PRIMARY KEY AUTOINCREMENT,
+				%s POLYGON,
+				tile_id TEXT NOT NULL,
+				date DATE NOT NULL,
+				acquired DATETIME NOT NULL,
+				UNIQUE (tile_id, acquired))`, quoteIdentifier(sqliteTableName), gpkgGeometryName),
+			fmt.Sprintf(`INSERT OR IGNORE INTO gpkg_contents (table_name, data_type, identifier, srs_id)
+				VALUES ('%s', 'features', '%s', 4326)`, sqliteTableName, sqliteTableName),
+			fmt.Sprintf(`INSERT OR IGNORE INTO gpkg_geometry_columns VALUES ('%s', '%s', 'POLYGON', 4326, 0, 0)`,
+				sqliteTableName, gpkgGeometryName),
+		)
+	} else {
+		statements = append(statements, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			tile_id TEXT NOT NULL,
+			date TEXT NOT NULL,
+			acquired TEXT NOT NULL,
+			min_lon REAL,
+			min_lat REAL,
+			max_lon REAL,
+			max_lat REAL,
+			PRIMARY KEY (tile_id, acquired))`, quoteIdentifier(sqliteTableName)))
+	}
+	statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (tile_id, date)",
+		quoteIdentifier(sqliteTableName+"_date"), quoteIdentifier(sqliteTableName)))
+	for _, statement := range statements {
+		if _, err := w.db.Exec(statement); err != nil {
+			return errors.Wrap(err, "failed to create results table")
+		}
+	}
+	return w.addColumns(valueNames)
+}
+
+// Adds the crs and value columns that are missing from the results table.
+func (w *sqliteResultWriter) addColumns(valueNames []string) error {
+	existing, err := w.columnNames()
+	if err != nil {
+		return err
+	}
+	if w.crs && !existing["crs"] {
+		_, err := w.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN crs TEXT", quoteIdentifier(sqliteTableName)))
+		if err != nil {
+			return errors.Wrap(err, "failed to add column crs")
+		}
+	}
+	for _, name := range valueNames {
+		if existing[name] {
+			continue
+		}
+		_, err := w.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s REAL",
+			quoteIdentifier(sqliteTableName), quoteIdentifier(name)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to add column %s", name)
+		}
+	}
+	return nil
+}
+
+// Returns the names of the columns in the results table.
+func (w *sqliteResultWriter) columnNames() (map[string]bool, error) {
+	rows, err := w.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdentifier(sqliteTableName)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read results table columns")
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var cid int
+		var name, columnType string
+		var notNull, primaryKey int
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, errors.Wrap(err, "failed to read results table columns")
+		}
+		columns[name] = true
+	}
+	return columns, errors.Wrap(rows.Err(), "failed to read results table columns")
+}
+
+// Returns the statement inserting a tile's row, or updating it if the tile has already been written.
+func (w *sqliteResultWriter) upsertSQL(valueNames []string) string {
+	keys := []string{"tile_id", "acquired"}
+	columns := []string{"date"}
+	if w.geoPackage {
+		columns = append(columns, gpkgGeometryName)
+	} else {
+		columns = append(columns, "min_lon", "min_lat", "max_lon", "max_lat")
+	}
+	if w.crs {
+		columns = append(columns, "crs")
+	}
+	columns = append(columns, valueNames...)
+
+	names := make([]string, 0, len(keys)+len(columns))
+	updates := make([]string, len(columns))
+	for _, key := range keys {
+		names = append(names, quoteIdentifier(key))
+	}
+	for i, column := range columns {
+		names = append(names, quoteIdentifier(column))
+		updates[i] = fmt.Sprintf("%s = excluded.%s", quoteIdentifier(column), quoteIdentifier(column))
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (tile_id, acquired) DO UPDATE SET %s",
+		quoteIdentifier(sqliteTableName), strings.Join(names, ", "), placeholders, strings.Join(updates, ", "))
+}
+
+func (w *sqliteResultWriter) write(result analytics.TileValues) error {
+	args := []interface{}{result.Tile.GeoHash, formatAcquired(result.Tile), formatDate(result.Tile)}
+	bounds := result.Bounds
+	if w.geoPackage {
+		args = append(args, gpkgPolygon(bounds))
+		w.extendExtent(bounds)
+	} else {
+		args = append(args, bounds.MinLon, bounds.MinLat, bounds.MaxLon, bounds.MaxLat)
+	}
+	if w.crs {
+		args = append(args, result.CRS)
+	}
+	for _, value := range result.Values {
+		args = append(args, value)
+	}
+
+	if _, err := w.tx.Stmt(w.insert).Exec(args...); err != nil {
+		return errors.Wrap(err, "failed to write row")
+	}
+
+	// commit periodically so that completed rows are kept if the run is interrupted
+	w.pending++
+	if w.pending < sqliteBatchSize {
+		return nil
+	}
+	w.pending = 0
+	if err := w.tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit rows")
+	}
+	tx, err := w.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	w.tx = tx
+	return nil
+}
+
+// Commits the remaining rows, updates the geopackage layer extent and closes the database.
+func (w *sqliteResultWriter) close() error {
+	if err := w.tx.Commit(); err != nil {
+		w.db.Close()
+		return errors.Wrap(err, "failed to commit rows")
+	}
+	if w.geoPackage && w.extent != nil {
+		_, err := w.db.Exec(`UPDATE gpkg_contents SET
+			min_x = min(coalesce(min_x, ?1), ?1), min_y = min(coalesce(min_y, ?2), ?2),
+			max_x = max(coalesce(max_x, ?3), ?3), max_y = max(coalesce(max_y, ?4), ?4),
+			last_change = strftime('%Y-%m-%dT%H:%M:%fZ','now')
+			WHERE table_name = ?5`,
+			w.extent.MinLon, w.extent.MinLat, w.extent.MaxLon, w.extent.MaxLat, sqliteTableName)
+		if err != nil {
+			w.db.Close()
+			return errors.Wrap(err, "failed to update layer extent")
+		}
+	}
+	return w.db.Close()
+}
+
+// Grows the layer extent to include the bounds.
+func (w *sqliteResultWriter) extendExtent(bounds analytics.GeoBounds) {
+	if w.extent == nil {
+		w.extent = &bounds
+		return
+	}
+	w.extent.MinLon = math.Min(w.extent.MinLon, bounds.MinLon)
+	w.extent.MinLat = math.Min(w.extent.MinLat, bounds.MinLat)
+	w.extent.MaxLon = math.Max(w.extent.MaxLon, bounds.MaxLon)
+	w.extent.MaxLat = math.Max(w.extent.MaxLat, bounds.MaxLat)
+}
+
+// Quotes a table or column name.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Encodes the bounds as a GeoPackage geometry blob - a header containing the envelope followed by
+// the polygon as little endian WKB.
+func gpkgPolygon(bounds analytics.GeoBounds) []byte {
+	ring := bounds.Ring()
+	blob := make([]byte, 0, 8+32+13+16*len(ring))
+	blob = append(blob, 'G', 'P', 0, gpkgGeometryFlags)
+	blob = appendUint32(blob, wgs84EPSGCode)
+	for _, value := range []float64{bounds.MinLon, bounds.MaxLon, bounds.MinLat, bounds.MaxLat} {
+		blob = appendUint64(blob, math.Float64bits(value))
+	}
+
+	blob = append(blob, 1)
+	blob = appendUint32(blob, wkbPolygon)
+	blob = appendUint32(blob, 1)
+	blob = appendUint32(blob, uint32(len(ring)))
+	for _, point := range ring {
+		blob = appendUint64(blob, math.Float64bits(point[0]))
+		blob = appendUint64(blob, math.Float64bits(point[1]))
+	}
+	return blob
+}
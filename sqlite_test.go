@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/uncharted-distil/tile-tx/analytics"
+)
+
+func TestGPKGPolygon(t *testing.T) {
+	bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+	blob := gpkgPolygon(bounds)
+
+	ring := bounds.Ring()
+	if len(blob) != 8+32+13+16*len(ring) {
+		t.Fatalf("blob is %d bytes", len(blob))
+	}
+	if string(blob[:2]) != "GP" || blob[2] != 0 || blob[3] != gpkgGeometryFlags {
+		t.Errorf("header magic, version and flags %v", blob[:4])
+	}
+	if srsID := binary.LittleEndian.Uint32(blob[4:]); srsID != wgs84EPSGCode {
+		t.Errorf("srs id %d, want %d", srsID, wgs84EPSGCode)
+	}
+	float := func(offset int) float64 {
+		return math.Float64frombits(binary.LittleEndian.Uint64(blob[offset:]))
+	}
+	envelope := []float64{float(8), float(16), float(24), float(32)}
+	if envelope[0] != bounds.MinLon || envelope[1] != bounds.MaxLon || envelope[2] != bounds.MinLat ||
+		envelope[3] != bounds.MaxLat {
+		t.Errorf("envelope %v", envelope)
+	}
+
+	// little endian WKB polygon with a single ring
+	wkb := blob[40:]
+	if wkb[0] != 1 || binary.LittleEndian.Uint32(wkb[1:]) != wkbPolygon || binary.LittleEndian.Uint32(wkb[5:]) != 1 ||
+		binary.LittleEndian.Uint32(wkb[9:]) != uint32(len(ring)) {
+		t.Errorf("wkb header %v", wkb[:13])
+	}
+	for i, point := range ring {
+		x, y := float(40+13+16*i), float(40+13+16*i+8)
+		if x != point[0] || y != point[1] {
+			t.Errorf("point %d is (%v, %v), want (%v, %v)", i, x, y, point[0], point[1])
+		}
+	}
+}
+
+// Writes the results to a database, closing the writer.
+func writeSQLiteResults(t *testing.T, outputFile string, valueNames []string, geoPackage bool, crs bool,
+	results ...analytics.TileValues) {
+	w, err := newSQLiteResultWriter(outputFile, valueNames, geoPackage, crs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if err := w.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteResultWriterUpsert(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	for _, geoPackage := range []bool{false, true} {
+		outputFile := path.Join(outputDir, "tiles.db")
+		if geoPackage {
+			outputFile = path.Join(outputDir, "tiles.gpkg")
+		}
+		tile := analytics.Tile{GeoHash: "9q8yy", Timestamp: 1577836800}
+		bounds := analytics.GeoBounds{MinLon: -122.5, MinLat: 37.5, MaxLon: -122, MaxLat: 38}
+		writeSQLiteResults(t, outputFile, []string{"ndvi"}, geoPackage, false,
+			analytics.TileValues{Tile: tile, Bounds: bounds, Values: []float64{0.25}},
+			analytics.TileValues{Tile: analytics.Tile{GeoHash: "9q8yz", Timestamp: 1577836800}, Bounds: bounds,
+				Values: []float64{0.5}})
+
+		// a second run updates the existing row and adds the new value and crs columns
+		writeSQLiteResults(t, outputFile, []string{"ndvi", "ndwi"}, geoPackage, true,
+			analytics.TileValues{Tile: tile, Bounds: bounds, CRS: "EPSG:32610", Values: []float64{0.75, 0.1}})
+
+		db, err := sql.Open("sqlite3", outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		if err := db.QueryRow("SELECT count(*) FROM tiles").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("geopackage %v: %d rows, want 2", geoPackage, count)
+		}
+
+		var ndvi, ndwi float64
+		var crs string
+		err = db.QueryRow("SELECT ndvi, ndwi, crs FROM tiles WHERE tile_id = ? AND date = ?",
+			"9q8yy", "2020-01-01").Scan(&ndvi, &ndwi, &crs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ndvi != 0.75 || ndwi != 0.1 || crs != "EPSG:32610" {
+			t.Errorf("geopackage %v: updated row has ndvi %v, ndwi %v and crs %s", geoPackage, ndvi, ndwi, crs)
+		}
+
+		if geoPackage {
+			var minX, minY, maxX, maxY float64
+			err = db.QueryRow("SELECT min_x, min_y, max_x, max_y FROM gpkg_contents WHERE table_name = 'tiles'").
+				Scan(&minX, &minY, &maxX, &maxY)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if minX != bounds.MinLon || minY != bounds.MinLat || maxX != bounds.MaxLon || maxY != bounds.MaxLat {
+				t.Errorf("layer extent (%v, %v, %v, %v)", minX, minY, maxX, maxY)
+			}
+		}
+		db.Close()
+	}
+}